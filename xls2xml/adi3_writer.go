@@ -0,0 +1,558 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	xw "github.com/shabbyrobe/xmlwriter"
+)
+
+// ADI 3.0 namespaces
+const (
+	adi3NS    = "http://www.cablelabs.com/namespaces/metadata/xsd/vod30/1"
+	coreNS    = "http://www.cablelabs.com/namespaces/metadata/xsd/core/1"
+	contentNS = "http://www.cablelabs.com/namespaces/metadata/xsd/content/1"
+	offerNS   = "http://www.cablelabs.com/namespaces/metadata/xsd/offer/1"
+	titleNS   = "http://www.cablelabs.com/namespaces/metadata/xsd/title/1"
+)
+
+// Options of the ADI 3.0 output
+const (
+	adi3RatingSystemOpt = "adi3_rating_system" // rating system of title:Rating
+	adi3TermOpt         = "adi3_term_"         // prefix of the options overriding the mapping of an App_Data name
+)
+
+const defaultRatingSystem = "DJCTQ"
+
+// Kinds of ADI 3.0 terms
+const (
+	textTerm   = iota
+	boolTerm   // Y/N converted to true/false
+	personTerm // "Last, First" converted to the attributes of core:PersonType
+	localTerm  // element of title:LocalizableTitle
+	personLocalTerm
+)
+
+// adi3TermT maps an App_Data name of ADI 1.1 to an ADI 3.0 element. Elements starting with '@' are
+// attributes of the asset, "-" drops the value and "offer:" elements are moved to the Offer
+type adi3TermT struct {
+	name string
+	elem string
+	kind int
+}
+
+// adi3Terms holds the default mapping, in the order of the elements in the ADI 3.0 schema. App_Data names
+// not mapped are kept in core:Ext
+var adi3Terms = []adi3TermT{
+	{"Type", "-", textTerm},
+	{"Metadata_Spec_Version", "-", textTerm},
+	{"Licensing_Window_Start", "@startDateTime", textTerm},
+	{"Licensing_Window_End", "@endDateTime", textTerm},
+	{"Provider_QA_Contact", "core:ProviderQAContact", textTerm},
+	{"Title_Sort_Name", "title:TitleSortName", localTerm},
+	{"Title_Brief", "title:TitleBrief", localTerm},
+	{"Title", "title:TitleMedium", localTerm},
+	{"Title_Long", "title:TitleLong", localTerm},
+	{"Summary_Short", "title:SummaryShort", localTerm},
+	{"Summary_Medium", "title:SummaryMedium", localTerm},
+	{"Summary_Long", "title:SummaryLong", localTerm},
+	{"Actors", "title:Actor", personLocalTerm},
+	{"Actors_Display", "title:ActorDisplay", localTerm},
+	{"Director", "title:Director", personLocalTerm},
+	{"Studio_Name", "title:StudioDisplayName", localTerm},
+	{"Episode_Name", "title:EpisodeName", localTerm},
+	{"Rating", "title:Rating", textTerm},
+	{"Closed_Captioning", "title:IsClosedCaptioning", boolTerm},
+	{"Display_Run_Time", "title:DisplayRunTime", textTerm},
+	{"Year", "title:Year", textTerm},
+	{"Country_of_Origin", "title:CountryOfOrigin", textTerm},
+	{"Studio", "title:Studio", textTerm},
+	{"Genre", "title:Genre", textTerm},
+	{"Show_Type", "title:ShowType", textTerm},
+	{"Episode_ID", "title:EpisodeID", textTerm},
+	{"Box_Office", "title:BoxOffice", textTerm},
+	{"Billing_ID", "offer:BillingId", textTerm},
+	{"Suggested_Price", "offer:SuggestedPrice", textTerm},
+	{"Preview_Period", "offer:PreviewPeriod", textTerm},
+	{"Contract_Name", "offer:ContractName", textTerm},
+	{"Content", "content:SourceUrl", textTerm},
+	{"Content_FileSize", "content:ContentFileSize", textTerm},
+	{"Content_CheckSum", "content:ContentCheckSum", textTerm},
+	{"Audio_Type", "content:AudioType", textTerm},
+	{"Screen_Format", "content:ScreenFormat", textTerm},
+	{"Languages", "content:Language", textTerm},
+	{"Subtitle_Languages", "content:SubtitleLanguage", textTerm},
+	{"Dubbed_Languages", "content:DubbedLanguage", textTerm},
+	{"HDContent", "content:HDContent", boolTerm},
+	{"Bit_Rate", "content:BitRate", textTerm},
+	{"Watermarking", "content:Watermarking", boolTerm},
+	{"Image_Aspect_Ratio", "content:ImageAspectRatio", textTerm},
+}
+
+// adi3Classes holds the ADI 3.0 element of each ADI 1.1 asset class and its reference in the ContentGroup
+var adi3Classes = map[string]struct{ elem, ref string }{
+	"package":   {"Offer", ""},
+	"title":     {"Title", "offer:TitleRef"},
+	"movie":     {"Movie", "offer:MovieRef"},
+	"preview":   {"Preview", "offer:PreviewRef"},
+	"poster":    {"Poster", "offer:PosterRef"},
+	"box cover": {"BoxCover", "offer:BoxCoverRef"},
+}
+
+// adiNodeT is an element of the ADI 1.1 document built by the config
+type adiNodeT struct {
+	name     string
+	attrs    map[string]string
+	text     string
+	children []*adiNodeT
+}
+
+func (n *adiNodeT) child(name string) *adiNodeT {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// adi3Writer writes ADI 3.0 files. The configs of ADI 1.1 are used: the document is built in memory and
+// converted when the file is written
+type adi3Writer struct {
+	run      *runT
+	fileName string
+	root     *adiNodeT
+	stack    []*adiNodeT
+	b        *bytes.Buffer
+	testing  bool
+}
+
+// newADI3Writer creates a new struct
+func newADI3Writer(run *runT, filename string) (*adi3Writer, error) {
+	return &adi3Writer{run: run, fileName: filename}, nil
+}
+
+// Suffix returns the output file extension
+func (wr *adi3Writer) Suffix() string {
+	return ".xml"
+}
+
+// Filename returns the output file name
+func (wr *adi3Writer) Filename() string {
+	return wr.fileName
+}
+
+// OpenOutput prepares to write an ADI 3.0 file
+func (wr *adi3Writer) OpenOutput() error {
+	wr.root = nil
+	wr.stack = nil
+	wr.b = &bytes.Buffer{}
+	return nil
+}
+
+// StartElem starts an ADI 1.1 element
+func (wr *adi3Writer) StartElem(name string, _ elemType) error {
+	n := &adiNodeT{name: name, attrs: make(map[string]string)}
+	if len(wr.stack) > 0 {
+		parent := wr.stack[len(wr.stack)-1]
+		parent.children = append(parent.children, n)
+	} else if wr.root == nil {
+		wr.root = n
+	}
+	wr.stack = append(wr.stack, n)
+	return nil
+}
+
+// EndElem closes an ADI 1.1 element
+func (wr *adi3Writer) EndElem(string, elemType) error {
+	if len(wr.stack) > 0 {
+		wr.stack = wr.stack[:len(wr.stack)-1]
+	}
+	return nil
+}
+
+// StartComment starts a comment section. Comments are not converted: its elements are discarded
+func (wr *adi3Writer) StartComment(string) error {
+	wr.stack = append(wr.stack, &adiNodeT{attrs: make(map[string]string)})
+	return nil
+}
+
+// EndComment closes a comment section
+func (wr *adi3Writer) EndComment(string) error {
+	return wr.EndElem("", emptyT)
+}
+
+func (wr *adi3Writer) Write(value string) error {
+	if len(wr.stack) > 0 {
+		wr.stack[len(wr.stack)-1].text += value
+	}
+	return nil
+}
+
+// WriteAttr adds an attribute to the current element
+func (wr *adi3Writer) WriteAttr(name string, value string, vtype string, attrType string) error {
+	val, err := xmlValue(name, value, vtype)
+	if attrType == "ott" {
+		_ = wr.Write(val)
+	} else if len(wr.stack) > 0 {
+		wr.stack[len(wr.stack)-1].attrs[name] = val
+	}
+	return err
+}
+
+// WriteAndClose converts the document and writes the file
+func (wr *adi3Writer) WriteAndClose(filename string) error {
+	if err := writeADI3(wr.b, wr.root, wr.run.options); err != nil {
+		return err
+	}
+	if wr.testing {
+		return nil
+	}
+	if err := ioutil.WriteFile(filename, wr.b.Bytes(), 0644); err != nil {
+		return newError(msgCreateFile, filename, err)
+	}
+	wr.run.registerOutput(filename)
+	return nil
+}
+
+func (wr *adi3Writer) getBuffer() []byte {
+	return wr.b.Bytes()
+}
+
+// WriteConsolidated writes additional files
+func (wr *adi3Writer) WriteConsolidated(int) ([]byte, []byte, []byte, error) {
+	return nil, nil, nil, nil
+}
+
+// StartMap starts a map element
+func (wr *adi3Writer) StartMap() error {
+	return nil
+}
+
+// EndMap closes a map element
+func (wr *adi3Writer) EndMap() error {
+	return nil
+}
+
+// Testing returns true if is running in a testing environment
+func (wr *adi3Writer) Testing() bool {
+	return wr.testing
+}
+
+// adi3AssetT is an asset of the ADI 3.0 document
+type adi3AssetT struct {
+	class    string
+	elem     string
+	uriID    string
+	ams      map[string]string
+	attrs    map[string]string
+	terms    map[int][]*adiNodeT // App_Data by term index
+	ext      []*adiNodeT         // App_Data not mapped
+	refs     []xw.Elem
+	children []xw.Writable
+}
+
+// adi3Term returns the index and the mapping of an App_Data name. The config options override the default
+// mapping, keeping the position and the kind of the element if it is a default one. Names not mapped
+// have index -1
+func adi3Term(name string, options optionsT) (int, adi3TermT) {
+	idx, term := -1, adi3TermT{name: name}
+	for i, t := range adi3Terms {
+		if t.name == name {
+			idx, term = i, t
+			break
+		}
+	}
+	elem, ok := options["options"][adi3TermOpt+name]
+	if !ok || elem == term.elem {
+		return idx, term
+	}
+	idx, term = len(adi3Terms), adi3TermT{name: name, elem: elem, kind: textTerm}
+	for i, t := range adi3Terms {
+		if t.elem == elem {
+			idx, term.kind = i, t.kind
+			break
+		}
+	}
+	return idx, term
+}
+
+// collectADIAssets returns the assets of an ADI 1.1 document, parents first
+func collectADIAssets(n *adiNodeT, options optionsT) (assets []*adi3AssetT) {
+	if md := n.child("Metadata"); md != nil {
+		if ams := md.child("AMS"); ams != nil {
+			class := strings.ToLower(ams.attrs["Asset_Class"])
+			a := &adi3AssetT{class: class, elem: adi3Classes[class].elem, ams: ams.attrs,
+				attrs: make(map[string]string), terms: make(map[int][]*adiNodeT)}
+			if a.elem == "" {
+				a.elem = "Asset"
+			}
+			a.uriID = ams.attrs["Provider_ID"] + "/" + ams.attrs["Asset_ID"]
+			for _, c := range md.children {
+				if c.name == "App_Data" {
+					a.addTerm(c.attrs["Name"], c, options)
+				}
+			}
+			if content := n.child("Content"); content != nil {
+				a.addTerm("Content", &adiNodeT{name: "Content", attrs: map[string]string{"Name": "Content", "Value": content.attrs["Value"]}}, options)
+			}
+			assets = append(assets, a)
+		}
+	}
+	for _, c := range n.children {
+		if c.name == "Asset" {
+			assets = append(assets, collectADIAssets(c, options)...)
+		}
+	}
+	return
+}
+
+// addTerm adds an App_Data value to the asset
+func (a *adi3AssetT) addTerm(name string, n *adiNodeT, options optionsT) {
+	idx, term := adi3Term(name, options)
+	switch {
+	case idx < 0:
+		a.ext = append(a.ext, n)
+	case term.elem == "-":
+	case strings.HasPrefix(term.elem, "@"):
+		a.attrs[term.elem[1:]] = n.attrs["Value"]
+	default:
+		a.terms[idx] = append(a.terms[idx], n)
+	}
+}
+
+// writeADI3 converts an ADI 1.1 document to ADI 3.0
+func writeADI3(b *bytes.Buffer, root *adiNodeT, options optionsT) error {
+	if root == nil || root.name != "ADI" {
+		name := ""
+		if root != nil {
+			name = root.name
+		}
+		return newError(msgADI3Root, name)
+	}
+	assets := collectADIAssets(root, options)
+	var offer, title *adi3AssetT
+	for _, a := range assets {
+		switch a.class {
+		case "package":
+			offer = a
+		case "title":
+			if title == nil {
+				title = a
+			}
+		}
+	}
+	var group *adi3AssetT
+	if offer != nil {
+		// offer terms of the other assets
+		for _, a := range assets {
+			if a == offer {
+				continue
+			}
+			for idx, values := range a.terms {
+				if _, term := adi3Term(values[0].attrs["Name"], options); strings.HasPrefix(term.elem, "offer:") {
+					offer.terms[idx] = append(offer.terms[idx], values...)
+					delete(a.terms, idx)
+				}
+			}
+		}
+		if title != nil {
+			for _, attr := range []string{"startDateTime", "endDateTime"} {
+				if _, ok := offer.attrs[attr]; !ok && title.attrs[attr] != "" {
+					offer.attrs[attr] = title.attrs[attr]
+				}
+			}
+		}
+		group = &adi3AssetT{class: "group", elem: "ContentGroup", uriID: offer.uriID + "_CG", ams: offer.ams,
+			attrs: map[string]string{}, terms: map[int][]*adiNodeT{}}
+		for _, a := range assets {
+			if ref := adi3Classes[a.class].ref; ref != "" {
+				group.refs = append(group.refs, adi3Elem(ref, xw.Attr{Name: "uriId", Value: a.uriID}))
+			}
+		}
+		offer.refs = append(offer.refs, adi3Elem("offer:ContentGroupRef", xw.Attr{Name: "uriId", Value: group.uriID}))
+	}
+	w := xw.Open(b, xw.WithIndentString("\t"))
+	ec := &xw.ErrCollector{}
+	ec.Do(w.StartDoc(xw.Doc{}))
+	ec.Do(w.StartElem(xw.Elem{Name: "ADI3", Attrs: []xw.Attr{
+		{Name: "xmlns", Value: adi3NS},
+		{Prefix: "xmlns", Name: "content", Value: contentNS},
+		{Prefix: "xmlns", Name: "core", Value: coreNS},
+		{Prefix: "xmlns", Name: "offer", Value: offerNS},
+		{Prefix: "xmlns", Name: "title", Value: titleNS},
+	}}))
+	ordered := assets
+	if offer != nil {
+		ordered = []*adi3AssetT{offer, group}
+		for _, a := range assets {
+			if a != offer {
+				ordered = append(ordered, a)
+			}
+		}
+	}
+	for _, a := range ordered {
+		writeADI3Elem(w, ec, a.element(options))
+	}
+	ec.Do(w.EndAllFlush())
+	if ec.Err != nil {
+		return newError(msgADI3Write, ec.Err)
+	}
+	return nil
+}
+
+// writeADI3Elem writes an element, starting each child element in its own line
+func writeADI3Elem(w *xw.Writer, ec *xw.ErrCollector, el xw.Elem) {
+	nested := false
+	for _, c := range el.Content {
+		if _, ok := c.(xw.Elem); ok {
+			nested = true
+		}
+	}
+	if !nested {
+		ec.Do(w.Write(el))
+		return
+	}
+	content := el.Content
+	el.Content = nil
+	ec.Do(w.StartElem(el))
+	for _, c := range content {
+		if child, ok := c.(xw.Elem); ok {
+			writeADI3Elem(w, ec, child)
+		} else {
+			ec.Do(w.Write(c))
+		}
+	}
+	ec.Do(w.EndElem())
+}
+
+// adi3Elem creates an element with a prefixed name
+func adi3Elem(name string, attrs ...xw.Attr) xw.Elem {
+	el := xw.Elem{Name: name, Attrs: attrs}
+	if i := strings.Index(name, ":"); i >= 0 {
+		el.Prefix, el.Name = name[:i], name[i+1:]
+	}
+	return el
+}
+
+// adi3Text creates an element with a text
+func adi3Text(name string, text string, attrs ...xw.Attr) xw.Elem {
+	el := adi3Elem(name, attrs...)
+	el.Content = []xw.Writable{xw.Text(text)}
+	return el
+}
+
+// adi3DateTime converts an ADI 1.1 date to a xs:dateTime
+func adi3DateTime(date string, end bool) string {
+	if len(date) != len("2006-01-02") {
+		return date
+	}
+	if end {
+		return date + "T23:59:59Z"
+	}
+	return date + "T00:00:00Z"
+}
+
+// element returns the ADI 3.0 element of the asset
+func (a *adi3AssetT) element(options optionsT) xw.Elem {
+	attrs := []xw.Attr{
+		{Name: "uriId", Value: a.uriID},
+		{Name: "providerVersionNum", Value: a.ams["Version_Major"]},
+		{Name: "internalVersionNum", Value: a.ams["Version_Minor"]},
+		{Name: "creationDateTime", Value: adi3DateTime(a.ams["Creation_Date"], false)},
+	}
+	if v, ok := a.attrs["startDateTime"]; ok {
+		attrs = append(attrs, xw.Attr{Name: "startDateTime", Value: adi3DateTime(v, false)})
+	}
+	if v, ok := a.attrs["endDateTime"]; ok {
+		attrs = append(attrs, xw.Attr{Name: "endDateTime", Value: adi3DateTime(v, true)})
+	}
+	names := make([]string, 0, len(a.attrs))
+	for name := range a.attrs {
+		if name != "startDateTime" && name != "endDateTime" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		attrs = append(attrs, xw.Attr{Name: name, Value: a.attrs[name]})
+	}
+	el := xw.Elem{Name: a.elem, Attrs: attrs}
+	if len(a.ext) > 0 {
+		ext := adi3Elem("core:Ext")
+		for _, n := range a.ext {
+			ext.Content = append(ext.Content, xw.Elem{Name: "App_Data", Attrs: []xw.Attr{
+				{Name: "App", Value: n.attrs["App"]}, {Name: "Name", Value: n.attrs["Name"]}, {Name: "Value", Value: n.attrs["Value"]}}})
+		}
+		el.Content = append(el.Content, ext)
+	}
+	if a.class != "group" {
+		el.Content = append(el.Content, adi3Text("core:AlternateId", "vod://"+a.ams["Provider_ID"]+"/"+a.ams["Asset_ID"],
+			xw.Attr{Name: "identifierSystem", Value: "VOD1.1"}))
+	}
+	idxs := make([]int, 0, len(a.terms))
+	for idx := range a.terms {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+	// the localizable terms are grouped in the position of the first one
+	local := adi3Elem("title:LocalizableTitle")
+	localPos := -1
+	for _, idx := range idxs {
+		for _, n := range a.terms[idx] {
+			if n.attrs["Value"] == "" {
+				// empty elements are not valid in ADI 3.0
+				continue
+			}
+			_, term := adi3Term(n.attrs["Name"], options)
+			te := adi3Value(term, n.attrs["Value"], options)
+			if term.kind != localTerm && term.kind != personLocalTerm {
+				el.Content = append(el.Content, te)
+				continue
+			}
+			if localPos < 0 {
+				localPos = len(el.Content)
+				el.Content = append(el.Content, nil)
+			}
+			local.Content = append(local.Content, te)
+		}
+	}
+	if localPos >= 0 {
+		el.Content[localPos] = local
+	}
+	for _, ref := range a.refs {
+		el.Content = append(el.Content, ref)
+	}
+	return el
+}
+
+// adi3Value creates the element of a term
+func adi3Value(term adi3TermT, value string, options optionsT) xw.Elem {
+	switch term.kind {
+	case boolTerm:
+		switch strings.ToUpper(value) {
+		case "Y":
+			value = "true"
+		case "N":
+			value = "false"
+		}
+	case personTerm, personLocalTerm:
+		attrs := []xw.Attr{{Name: "fullName", Value: value}}
+		if parts := strings.SplitN(value, ",", 2); len(parts) == 2 {
+			last, first := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+			attrs = []xw.Attr{{Name: "fullName", Value: first + " " + last}, {Name: "firstName", Value: first},
+				{Name: "lastName", Value: last}, {Name: "sortableName", Value: value}}
+		}
+		return adi3Elem(term.elem, attrs...)
+	}
+	if term.elem == "title:Rating" {
+		system := options["options"][adi3RatingSystemOpt]
+		if system == "" {
+			system = defaultRatingSystem
+		}
+		return adi3Text(term.elem, value, xw.Attr{Name: "ratingSystem", Value: system})
+	}
+	return adi3Text(term.elem, value)
+}
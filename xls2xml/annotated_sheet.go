@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/plandem/xlsx"
+	"github.com/plandem/xlsx/format/styles"
+	"github.com/plandem/xlsx/types"
+)
+
+// Suffix of the annotated copy of the input workbook
+const annotatedSuffix = "_anotado"
+
+// Values of the status column of the annotated workbook
+const (
+	statusOK    = "OK"
+	statusError = "ERRO"
+)
+
+// rowStatusT is the result of the processing of a spreadsheet row
+type rowStatusT struct {
+	ok   bool
+	file string // file generated from the row
+}
+
+// setRowStatus records the file generated from lines
+func (r *runT) setRowStatus(lines []lineT, file string, ok bool) {
+	for _, l := range lines {
+		r.rowStatus[l.idx] = rowStatusT{ok: ok, file: file}
+	}
+}
+
+// discardRowFiles forgets the files generated from the rows, when they are not delivered
+func (r *runT) discardRowFiles() {
+	for row, st := range r.rowStatus {
+		st.file = ""
+		r.rowStatus[row] = st
+	}
+}
+
+// annotatedFilename returns the name of the annotated copy of the input workbook
+func annotatedFilename(inputXls string, outDir string) string {
+	base := path.Base(inputXls)
+	return path.Join(outDir, strings.TrimSuffix(base, path.Ext(base))+annotatedSuffix+path.Ext(base))
+}
+
+// writeAnnotatedSheet writes a copy of the input workbook where the cells with errors (or warnings) are
+// highlighted and commented, adding status and file columns after the last column of the sheet
+func (r *runT) writeAnnotatedSheet(inputXls string, info *sheetInfoT, outDir string) (string, error) {
+	sheetName := info.name
+	f, err := xlsx.Open(inputXls)
+	if err != nil {
+		return "", newError(msgOpenSheet, inputXls, err)
+	}
+	defer closeSheet(f)
+	sheet := f.SheetByName(sheetName)
+	if sheet == nil {
+		return "", newError(msgSheetNotFound, sheetName)
+	}
+	_, nRows := sheet.Dimension()
+	errorStyle := f.AddStyles(styles.New(
+		styles.Fill.Color("#ffc7ce"),
+		styles.Fill.Background("#ffc7ce"),
+		styles.Fill.Type(styles.PatternTypeSolid),
+		styles.Font.Color("#9c0006"),
+	))
+	warningStyle := f.AddStyles(styles.New(
+		styles.Fill.Color("#ffeb9c"),
+		styles.Fill.Background("#ffeb9c"),
+		styles.Fill.Type(styles.PatternTypeSolid),
+		styles.Font.Color("#9c5700"),
+	))
+	headerStyle := f.AddStyles(styles.New(
+		styles.Fill.Color("#a0a0a0"),
+		styles.Fill.Background("#a0a0a0"),
+		styles.Fill.Type(styles.PatternTypeSolid),
+		styles.Font.Bold,
+	))
+	// Error messages by cell and rows with errors
+	comments := make(map[string][]string)
+	rowErrors := make(map[int]bool)
+	errorCells := make(map[string]bool)
+	for _, rec := range r.errorRecords {
+		if !strings.EqualFold(rec.Sheet, sheetName) || rec.Row == 0 {
+			continue
+		}
+		if rec.Severity != severityWarning {
+			rowErrors[rec.Row] = true
+		}
+		if rec.Column != "" {
+			ref := rec.Column + strconv.Itoa(rec.Row)
+			comments[ref] = append(comments[ref], fmt.Sprintf("[%s] %s", rec.Element, rec.Message))
+			if rec.Severity != severityWarning {
+				errorCells[ref] = true
+			}
+		}
+	}
+	refs := make([]string, 0, len(comments))
+	for ref := range comments {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	for _, ref := range refs {
+		cell := sheet.CellByRef(types.CellRef(ref))
+		if errorCells[ref] {
+			cell.SetStyles(errorStyle)
+		} else {
+			cell.SetStyles(warningStyle)
+		}
+		if err = setComment(cell, strings.Join(comments[ref], "\n")); err != nil {
+			return "", newError(msgCommentCell, ref, err)
+		}
+	}
+	// Status and file columns
+	statusCol := len(info.header)
+	sheet.Cell(statusCol, 0).SetValue("status")
+	sheet.Cell(statusCol, 0).SetStyles(headerStyle)
+	sheet.Cell(statusCol+1, 0).SetValue("arquivo gerado")
+	sheet.Cell(statusCol+1, 0).SetStyles(headerStyle)
+	for row := 1; row < nRows; row++ {
+		st, processed := r.rowStatus[row+1]
+		if !processed && !rowErrors[row+1] {
+			continue
+		}
+		status := statusOK
+		if !st.ok || rowErrors[row+1] {
+			status = statusError
+		}
+		sheet.Cell(statusCol, row).SetValue(status)
+		if status == statusError {
+			sheet.Cell(statusCol, row).SetStyles(errorStyle)
+		}
+		sheet.Cell(statusCol+1, row).SetValue(st.file)
+	}
+	filename := annotatedFilename(inputXls, outDir)
+	if err = f.SaveAs(filename); err != nil {
+		return "", newError(msgCreateFile, filename, err)
+	}
+	return filename, nil
+}
+
+// setComment adds a comment to a cell. The xlsx library panics with some workbooks that already have comments
+func setComment(cell *xlsx.Cell, comment string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return cell.SetComment(comment)
+}
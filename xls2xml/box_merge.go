@@ -0,0 +1,302 @@
+package main
+
+import (
+	js "encoding/json"
+	"path"
+	"strings"
+)
+
+// Option of the Box catalog merge
+const boxMergeOpt = "box_merge" // directory of the catalog delivered before, where the files of the run are merged
+
+// mergeCountT counts the changes of a catalog file
+type mergeCountT struct {
+	Added   int `json:"added"`
+	Updated int `json:"updated"`
+	Removed int `json:"removed"`
+}
+
+// boxMergerT merges the Box files of a run into the catalog delivered before, so each delivery has the
+// full catalog
+type boxMergerT struct {
+	dir     string
+	prev    map[string][]interface{} // entries of the previous files, by file
+	now     int64                    // time of the run, in milliseconds: assets available until before it are removed
+	deleted map[string]bool          // assets marked for deletion by the verb column
+	counts  map[string]mergeCountT   // changes, by file
+}
+
+// newBoxMerger reads the previous catalog given by the option 'box_merge'. Returns nil if the option is not set
+func newBoxMerger(opts optionsT) (*boxMergerT, error) {
+	dir := opts["options"][boxMergeOpt]
+	if dir == "" {
+		return nil, nil
+	}
+	m := &boxMergerT{dir: dir, prev: make(map[string][]interface{}), now: timeToUTCTimestamp(runTime(opts)),
+		deleted: make(map[string]bool), counts: make(map[string]mergeCountT)}
+	log(msg(msgBoxMerging, dir))
+	lists := map[string]string{boxAssetsFile: "assets", boxCategoriesFile: "categories", boxSeriesFile: "series"}
+	for file, list := range lists {
+		filename := path.Join(dir, file)
+		var doc map[string]interface{}
+		if err := readJSONFile(filename, &doc, msgBoxCatalog); err != nil {
+			return nil, err
+		}
+		if doc == nil {
+			// first delivery
+			continue
+		}
+		entries, ok := doc[list].([]interface{})
+		if !ok && doc[list] != nil {
+			return nil, newError(msgBoxCatalog, filename, list)
+		}
+		m.prev[file] = entries
+	}
+	return m, nil
+}
+
+// markDeleted records the assets of the rows whose verb column is DELETE
+func (m *boxMergerT) markDeleted(lines []lineT, idField string, verbField string) {
+	if verbField == "" {
+		return
+	}
+	for _, line := range lines {
+		if strings.EqualFold(strings.TrimSpace(line.fields[verbField]), verbDelete) {
+			m.deleted[strings.TrimSpace(line.fields[idField])] = true
+		}
+	}
+}
+
+// merge returns the documents of the run merged into the previous catalog. categs is nil if the run has no
+// categories file. Entries are upserted by id; assets whose license ended or marked for deletion are removed,
+// and so are the series and categories left without assets by the removals
+func (m *boxMergerT) merge(assets interface{}, categs interface{}, series interface{}) (interface{}, interface{}, interface{}, error) {
+	docs := make(map[string]map[string]interface{})
+	for file, doc := range map[string]interface{}{boxAssetsFile: assets, boxCategoriesFile: categs, boxSeriesFile: series} {
+		if doc == nil {
+			continue
+		}
+		val, err := normalizeJSON(doc)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		docs[file], _ = val.(map[string]interface{})
+	}
+	if docs[boxAssetsFile] == nil {
+		docs[boxAssetsFile] = map[string]interface{}{"assets": []interface{}{}}
+	}
+
+	// assets
+	runAssets := entryList(docs[boxAssetsFile], "assets")
+	mergedAssets := upsert(m.prev[boxAssetsFile], runAssets, nil)
+	removed := make(map[string]bool)
+	kept := make([]interface{}, 0, len(mergedAssets))
+	for _, a := range mergedAssets {
+		id := entryID(a)
+		if m.deleted[id] || m.expired(a) {
+			removed[id] = true
+			continue
+		}
+		kept = append(kept, a)
+	}
+	docs[boxAssetsFile]["assets"] = kept
+	m.count(boxAssetsFile, kept, runAssets)
+
+	// series: the ones whose episodes were all removed go too
+	if docs[boxSeriesFile] != nil {
+		run := entryList(docs[boxSeriesFile], "series")
+		mergedSeries := upsert(m.prev[boxSeriesFile], run, mergeSeasons)
+		used := make(map[string]bool)
+		orphan := make(map[string]bool)
+		for _, a := range kept {
+			used[stringField(a, "series_id")] = true
+		}
+		for _, a := range mergedAssets {
+			if s := stringField(a, "series_id"); removed[entryID(a)] && !used[s] {
+				orphan[s] = true
+			}
+		}
+		keptSeries := make([]interface{}, 0, len(mergedSeries))
+		for _, s := range mergedSeries {
+			if id := entryID(s); orphan[id] {
+				removed[id] = true
+				continue
+			}
+			keptSeries = append(keptSeries, s)
+		}
+		docs[boxSeriesFile]["series"] = keptSeries
+		m.count(boxSeriesFile, keptSeries, run)
+	}
+
+	// categories: the removed ids leave the lists, and the categories emptied by it are removed
+	if docs[boxCategoriesFile] != nil {
+		run := entryList(docs[boxCategoriesFile], "categories")
+		mergedCategs := upsert(m.prev[boxCategoriesFile], run, mergeCategLists)
+		for changed := true; changed; {
+			changed = false
+			keptCategs := make([]interface{}, 0, len(mergedCategs))
+			for _, c := range mergedCategs {
+				if categ, ok := c.(map[string]interface{}); ok && removeIDs(categ, removed) {
+					removed[entryID(c)] = true
+					changed = true
+					continue
+				}
+				keptCategs = append(keptCategs, c)
+			}
+			mergedCategs = keptCategs
+		}
+		docs[boxCategoriesFile]["categories"] = mergedCategs
+		m.count(boxCategoriesFile, mergedCategs, run)
+	}
+
+	var categsDoc, seriesDoc interface{}
+	if docs[boxCategoriesFile] != nil {
+		categsDoc = docs[boxCategoriesFile]
+	}
+	if docs[boxSeriesFile] != nil {
+		seriesDoc = docs[boxSeriesFile]
+	}
+	return docs[boxAssetsFile], categsDoc, seriesDoc, nil
+}
+
+// expired returns true if the license of an asset ended before the run
+func (m *boxMergerT) expired(asset interface{}) bool {
+	a, _ := asset.(map[string]interface{})
+	to, ok := a["available_to"].(js.Number)
+	if !ok {
+		return false
+	}
+	ms, err := to.Int64()
+	return err == nil && ms < m.now
+}
+
+// count records and logs the changes of a file: entries of the merged file that were not in the previous one
+// were added, the ones written by the run were updated, and the previous ones missing in the merged file were
+// removed
+func (m *boxMergerT) count(file string, merged []interface{}, run []interface{}) {
+	prev := make(map[string]bool)
+	for _, e := range m.prev[file] {
+		prev[entryID(e)] = true
+	}
+	inRun := make(map[string]bool)
+	for _, e := range run {
+		inRun[entryID(e)] = true
+	}
+	var c mergeCountT
+	for _, e := range merged {
+		id := entryID(e)
+		switch {
+		case !prev[id]:
+			c.Added++
+		case inRun[id]:
+			c.Updated++
+		}
+		delete(prev, id)
+	}
+	c.Removed = len(prev)
+	m.counts[file] = c
+	log(msg(msgBoxMerged, file, c.Added, c.Updated, c.Removed))
+}
+
+// upsert returns the previous entries with the ones of the run: an entry with the id of a previous one
+// replaces it, or is combined with it by join if given
+func upsert(prev []interface{}, run []interface{}, join func(prev, cur map[string]interface{})) []interface{} {
+	merged := make([]interface{}, 0, len(prev)+len(run))
+	index := make(map[string]int)
+	for _, e := range prev {
+		index[entryID(e)] = len(merged)
+		merged = append(merged, e)
+	}
+	for _, e := range run {
+		i, ok := index[entryID(e)]
+		if !ok {
+			index[entryID(e)] = len(merged)
+			merged = append(merged, e)
+			continue
+		}
+		p, okP := merged[i].(map[string]interface{})
+		cur, okC := e.(map[string]interface{})
+		if join != nil && okP && okC {
+			join(p, cur)
+		}
+		merged[i] = e
+	}
+	return merged
+}
+
+// mergeSeasons keeps in a series the previous seasons not written by the run
+func mergeSeasons(prev map[string]interface{}, cur map[string]interface{}) {
+	seasons, _ := cur["seasons"].([]interface{})
+	prevSeasons, _ := prev["seasons"].([]interface{})
+	cur["seasons"] = upsert(prevSeasons, seasons, nil)
+}
+
+// mergeCategLists keeps in a category the previous assets and series: the run knows only its rows
+func mergeCategLists(prev map[string]interface{}, cur map[string]interface{}) {
+	for _, list := range []string{"assets", "series"} {
+		_, inPrev := prev[list]
+		_, inCur := cur[list]
+		if !inPrev && !inCur {
+			continue
+		}
+		ids := idList(prev[list])
+		seen := make(map[string]bool)
+		for _, id := range ids {
+			seen[id] = true
+		}
+		for _, id := range idList(cur[list]) {
+			if !seen[id] {
+				ids = append(ids, id)
+				seen[id] = true
+			}
+		}
+		vals := make([]interface{}, 0, len(ids))
+		for _, id := range ids {
+			vals = append(vals, id)
+		}
+		cur[list] = vals
+	}
+}
+
+// removeIDs takes the removed ids out of the lists of a category. Returns true if the category had ids and
+// was left empty
+func removeIDs(categ map[string]interface{}, removed map[string]bool) bool {
+	had, left := 0, 0
+	for _, list := range []string{"assets", "series"} {
+		ids, ok := categ[list].([]interface{})
+		if !ok {
+			continue
+		}
+		vals := make([]interface{}, 0, len(ids))
+		for _, id := range ids {
+			if s, _ := id.(string); s != "" {
+				had++
+				if removed[s] {
+					continue
+				}
+				left++
+			}
+			vals = append(vals, id)
+		}
+		categ[list] = vals
+	}
+	return had > 0 && left == 0
+}
+
+// entryList returns the entries of a list of a document
+func entryList(doc map[string]interface{}, list string) []interface{} {
+	entries, _ := doc[list].([]interface{})
+	return entries
+}
+
+// entryID returns the id of an entry of the catalog
+func entryID(entry interface{}) string {
+	return stringField(entry, "id")
+}
+
+// stringField returns a string field of an entry of the catalog, or ""
+func stringField(entry interface{}, name string) string {
+	e, _ := entry.(map[string]interface{})
+	s, _ := e[name].(string)
+	return s
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Knetic/govaluate"
+)
+
+// Elements attached to the categories of a tree
+const (
+	attachChild  = ""       // the category of the next level; the asset at the last level
+	attachAssets = "assets" // the asset of the row
+	attachSeries = "series" // the series of the row, or its asset if it is not an episode
+)
+
+// Fields of a category that a level can set, like its visibility. The default values are the ones of newCateg
+var categFlags = []string{"hidden", "morality_level", "parental_control", "adult", "downloadable", "offline"}
+
+// Placeholders of the category templates: {field:<column>[:<length>]}, {option:<name>}, {uuid} (UUID of the
+// category name), {parent} (id of the category of the previous level), {series_id}, {season_id}
+var categPlaceholders = []string{"field", "option", "uuid", "parent", "series_id", "season_id"}
+
+// categLevelT is a level of a category tree
+type categLevelT struct {
+	name     string // template of the category name
+	id       string // template of the category id
+	filter   string // expression: the rows where it is false stop at the previous level
+	attach   string
+	existing bool                   // the category must be in the 'categories' sheet, which gives its id
+	flags    map[string]interface{} // values of categFlags
+}
+
+// categTreeT is a category tree of the config section 'category_tree'. Each row of the main sheet creates
+// a branch of the tree, from the first level to the last one whose name is not empty
+type categTreeT struct {
+	name        string
+	seriesField string // column of the main sheet with the title of the series, as in the 'series' sheet
+	seasonField string // column of the main sheet with the season number
+	levels      []categLevelT
+}
+
+// readCategTrees reads the 'category_tree' section of the config, checking the templates and expressions.
+// Without it, the categories are the studio/series/season tree and the genres
+func readCategTrees(json map[string]interface{}) ([]categTreeT, error) {
+	jTrees, ok := json["category_tree"].([]interface{})
+	if !ok {
+		return nil, nil
+	}
+	trees := make([]categTreeT, 0, len(jTrees))
+	for i, jt := range jTrees {
+		m, okM := jt.(map[string]interface{})
+		jLevels, okL := m["levels"].([]interface{})
+		if !okM || !okL || len(jLevels) == 0 {
+			return nil, newError(msgCategTree, i+1, jt)
+		}
+		tree := categTreeT{name: fmt.Sprint(i + 1)}
+		if name, _ := m["Name"].(string); name != "" {
+			tree.name = name
+		}
+		tree.seriesField, _ = m["series_field"].(string)
+		tree.seriesField = strings.ToLower(tree.seriesField)
+		tree.seasonField, _ = m["season_field"].(string)
+		tree.seasonField = strings.ToLower(tree.seasonField)
+		for j, jl := range jLevels {
+			level, err := readCategLevel(tree.name, j+1, jl)
+			if err != nil {
+				return nil, err
+			}
+			tree.levels = append(tree.levels, level)
+		}
+		trees = append(trees, tree)
+	}
+	return trees, nil
+}
+
+// readCategLevel reads a level of a category tree
+func readCategLevel(tree string, n int, jl interface{}) (categLevelT, error) {
+	m, ok := jl.(map[string]interface{})
+	if !ok {
+		return categLevelT{}, newError(msgCategLevelValue, tree, n, jl, "levels")
+	}
+	level := categLevelT{id: "{uuid}", flags: make(map[string]interface{})}
+	level.name, _ = m["name"].(string)
+	if level.name == "" {
+		return level, newError(msgCategLevelName, tree, n)
+	}
+	if id, _ := m["id"].(string); id != "" {
+		level.id = id
+	}
+	for key, tmpl := range map[string]string{"name": level.name, "id": level.id} {
+		for _, p := range templateRegexp.FindAllStringSubmatch(tmpl, -1) {
+			if !contains(categPlaceholders, p[1]) {
+				return level, newError(msgCategLevelValue, tree, n, p[0], key)
+			}
+		}
+	}
+	level.filter, _ = m["filter"].(string)
+	if level.filter != "" {
+		if _, err := govaluate.NewEvaluableExpressionWithFunctions(strings.ToLower(level.filter), exprFunctions); err != nil {
+			return level, newError(msgCategLevelValue, tree, n, level.filter, "filter")
+		}
+	}
+	level.attach, _ = m["attach"].(string)
+	if !contains([]string{attachChild, attachAssets, attachSeries}, level.attach) {
+		return level, newError(msgCategLevelValue, tree, n, level.attach, "attach")
+	}
+	if val, okE := m["existing"]; okE {
+		if level.existing, ok = configBool(val); !ok {
+			return level, newError(msgCategLevelValue, tree, n, val, "existing")
+		}
+	}
+	for _, flag := range categFlags {
+		val, okF := m[flag]
+		if !okF {
+			continue
+		}
+		if flag == "morality_level" {
+			level.flags[flag] = strings.TrimSpace(fmt.Sprint(val))
+			continue
+		}
+		if level.flags[flag], ok = configBool(val); !ok {
+			return level, newError(msgCategLevelValue, tree, n, val, flag)
+		}
+	}
+	return level, nil
+}
+
+// configBool converts a boolean of the config, given as a JSON boolean or as a string
+func configBool(val interface{}) (bool, bool) {
+	switch v := val.(type) {
+	case bool:
+		return v, true
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// categBranchT is the category of a level for a row
+type categBranchT struct {
+	level    *categLevelT
+	name     string
+	id       string
+	seriesID string
+	seasonID string
+}
+
+// processCategTree adds the branch of a row to the categories. With genresOnly, the branch stops at the first
+// level that is not in the 'categories' sheet
+func (wr *jsonWriter) processCategTree(tree *categTreeT, line *lineT, idField string, genresOnly bool) error {
+	seriesID, seasonID := "", ""
+	if tree.seriesField != "" {
+		if series, ok := wr.run.options["series"]; ok {
+			// rows that are not episodes have no series
+			seriesID, seasonID, _ = findInSerieMap(line.fields[tree.seriesField], line.fields[tree.seasonField], series)
+		}
+	}
+	var branch []categBranchT
+	parent := ""
+	for i := range tree.levels {
+		level := &tree.levels[i]
+		if genresOnly && !level.existing {
+			break
+		}
+		if level.filter != "" {
+			apply, err := evalCondition(strings.ToLower(level.filter), line)
+			if err != nil {
+				return err
+			}
+			if !apply {
+				break
+			}
+		}
+		usesSeries := strings.Contains(level.id, "{series_id}") || strings.Contains(level.id, "{season_id}")
+		if usesSeries && seriesID == "" {
+			// level of the series
+			break
+		}
+		b := categBranchT{level: level}
+		b.name = strings.TrimSpace(expandCategTemplate(level.name, line, wr.run.options, "", parent, seriesID, seasonID))
+		if b.name == "" {
+			break
+		}
+		if level.existing {
+			categ, err := findCateg(wr.categLines, b.name)
+			if err != nil {
+				return err
+			}
+			b.id = categ.fields["id"]
+		} else {
+			uuid, err := UUIDfromString(b.name)
+			if err != nil {
+				return err
+			}
+			b.id = expandCategTemplate(level.id, line, wr.run.options, uuid, parent, seriesID, seasonID)
+		}
+		b.seriesID, b.seasonID = "null", "null"
+		if usesSeries {
+			b.seriesID = seriesID
+		}
+		if strings.Contains(level.id, "{season_id}") {
+			b.seasonID = seasonID
+		}
+		branch = append(branch, b)
+		parent = b.id
+	}
+	assetID := line.fields[strings.ToLower(idField)]
+	for i, b := range branch {
+		categ := wr.treeCateg(b, i, branch)
+		if i < len(branch)-1 {
+			// the series tree categories list their child categories in 'assets'
+			addCategElem(categ, "assets", branch[i+1].id, b.name)
+		}
+		switch {
+		case b.level.attach == attachSeries && seriesID != "":
+			addCategElem(categ, "series", seriesID, b.name)
+		case b.level.attach != attachChild, i == len(branch)-1:
+			addCategElem(categ, "assets", assetID, b.name)
+		}
+	}
+	return nil
+}
+
+// treeCateg returns the category of a branch level, creating it if needed
+func (wr *jsonWriter) treeCateg(b categBranchT, i int, branch []categBranchT) map[string]interface{} {
+	r := wr.root.(map[string]interface{})
+	categs := r["categories"].([]map[string]interface{})
+	for _, categ := range categs {
+		if categ["id"] == b.id {
+			return categ
+		}
+	}
+	parent := ""
+	if i > 0 {
+		parent = branch[i-1].id
+	}
+	log(msg(msgNewCateg, b.name, b.id, parent))
+	categ, _, _ := newCateg(make(map[string]interface{}), b.name, b.id, parent, b.seriesID, b.seasonID, "")
+	for flag, val := range b.level.flags {
+		categ[flag] = val
+	}
+	r["categories"] = append(categs, categ)
+	return categ
+}
+
+// addCategElem adds an id to a list of a category, if not there
+func addCategElem(categ map[string]interface{}, element string, id string, name string) {
+	if id == "" {
+		return
+	}
+	list, _ := categ[element].([]interface{})
+	for _, el := range list {
+		if el == id {
+			return
+		}
+	}
+	log(msg(msgAddingCateg, name, id))
+	categ[element] = append(list, id)
+}
+
+// expandCategTemplate returns the value of a category template for a row
+func expandCategTemplate(tmpl string, line *lineT, options optionsT, uuid string, parent string, seriesID string, seasonID string) string {
+	return templateRegexp.ReplaceAllStringFunc(tmpl, func(s string) string {
+		m := templateRegexp.FindStringSubmatch(s)
+		switch m[1] {
+		case "field":
+			return fieldPlaceholder(m[2], line)
+		case "option":
+			return options["options"][m[2]]
+		case "uuid":
+			return uuid
+		case "parent":
+			return parent
+		case "series_id":
+			return seriesID
+		default:
+			// validated by readCategLevel
+			return seasonID
+		}
+	})
+}
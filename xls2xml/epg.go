@@ -0,0 +1,369 @@
+package main
+
+import (
+	js "encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sheet with the schedule of the channels, read by -outtype epg
+const epgSheet = "programacao"
+
+// Options of the EPG output
+const (
+	epgChannelFieldOpt = "epg_channel_field" // column with the channel of the programme
+	epgStartFieldOpt   = "epg_start_field"   // column with the start of the programme
+	epgEndFieldOpt     = "epg_end_field"     // column with the end of the programme
+	epgTimeFormatOpt   = "epg_time_format"   // layout of the times given as text, in the Go format
+	epgUTCOffsetOpt    = "epg_utc_offset"    // UTC offset of the times of the sheet, like -0300
+	epgMaxGapOpt       = "epg_max_gap"       // minutes allowed between two programmes of a channel. Default: gaps not checked
+	epgFileOpt         = "epg_file"          // name of the XMLTV file, without extension
+)
+
+// Defaults of the EPG options
+const (
+	defaultEPGTimeFormat = "02/01/2006 15:04"
+	defaultEPGUTCOffset  = "-0300"
+	defaultEPGFile       = "epg"
+)
+
+// Time format of the XMLTV attributes start and stop
+const xmltvTimeFormat = "20060102150405 -0700"
+
+// Box EPG file with the broadcasts of the channels
+const boxBroadcastsFile = "broadcasts.json"
+
+// Excel serial dates count the days since this date
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// epgProgT is a programme of the schedule
+type epgProgT struct {
+	line    *lineT
+	channel string
+	start   time.Time
+	end     time.Time
+}
+
+// epgConfigT holds the options of the EPG output
+type epgConfigT struct {
+	channelField string
+	startField   string
+	endField     string
+	layout       string
+	loc          *time.Location
+	maxGap       time.Duration
+	checkGaps    bool // false if 'epg_max_gap' is not set
+	file         string
+}
+
+// newEPGConfig reads the EPG options
+func newEPGConfig(opts optionsT) (*epgConfigT, error) {
+	c := &epgConfigT{
+		channelField: strings.ToLower(opts["options"][epgChannelFieldOpt]),
+		startField:   strings.ToLower(opts["options"][epgStartFieldOpt]),
+		endField:     strings.ToLower(opts["options"][epgEndFieldOpt]),
+		layout:       opts["options"][epgTimeFormatOpt],
+		file:         opts["options"][epgFileOpt],
+	}
+	for opt, val := range map[string]string{epgChannelFieldOpt: c.channelField, epgStartFieldOpt: c.startField,
+		epgEndFieldOpt: c.endField} {
+		if val == "" {
+			return nil, newError(msgOptionValue, opt, val)
+		}
+	}
+	if c.layout == "" {
+		c.layout = defaultEPGTimeFormat
+	}
+	if c.file == "" {
+		c.file = defaultEPGFile
+	}
+	var err error
+	if c.loc, err = epgLocation(opts); err != nil {
+		return nil, err
+	}
+	if gap := opts["options"][epgMaxGapOpt]; gap != "" {
+		minutes, errA := strconv.Atoi(gap)
+		if errA != nil || minutes < 0 {
+			return nil, newError(msgOptionValue, epgMaxGapOpt, gap)
+		}
+		c.maxGap = time.Duration(minutes) * time.Minute
+		c.checkGaps = true
+	}
+	return c, nil
+}
+
+// epgLocation returns the time zone of the times of the sheet, given by the option 'epg_utc_offset'
+func epgLocation(opts optionsT) (*time.Location, error) {
+	offset := opts["options"][epgUTCOffsetOpt]
+	if offset == "" {
+		offset = defaultEPGUTCOffset
+	}
+	t, err := time.Parse("-0700", offset)
+	if err != nil {
+		return nil, newError(msgOptionValue, epgUTCOffsetOpt, offset)
+	}
+	_, secs := t.Zone()
+	return time.FixedZone(offset, secs), nil
+}
+
+// parseEPGTime converts a time of the schedule: Excel date-time cells are read as serial numbers,
+// text cells must follow the layout
+func parseEPGTime(value string, layout string, loc *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if serial, err := strconv.ParseFloat(value, 64); err == nil {
+		secs := int64(math.Round(serial * 86400))
+		wall := excelEpoch.Add(time.Duration(secs) * time.Second)
+		return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, loc), nil
+	}
+	t, err := time.ParseInLocation(layout, value, loc)
+	if err != nil {
+		return t, newError(msgEPGTime, value, layout)
+	}
+	return t, nil
+}
+
+// epgTime returns a time of the schedule in the format given by the element 'format': xmltv (default)
+// or epoch, in seconds
+func epgTime(forceVal string, line *lineT, json jsonT, options optionsT) ([]resultsT, error) {
+	value, err := getField(forceVal, "", line, json, options)
+	if err != nil {
+		return errorMessage, err
+	}
+	layout := options["options"][epgTimeFormatOpt]
+	if layout == "" {
+		layout = defaultEPGTimeFormat
+	}
+	loc, err := epgLocation(options)
+	if err != nil {
+		return errorMessage, err
+	}
+	t, err := parseEPGTime(value, layout, loc)
+	if err != nil {
+		return errorMessage, err
+	}
+	format, _ := json["format"].(string)
+	switch format {
+	case "", "xmltv":
+		return []resultsT{newResult(t.Format(xmltvTimeFormat))}, nil
+	case "epoch":
+		return []resultsT{newResult(strconv.FormatInt(t.Unix(), 10))}, nil
+	}
+	return errorMessage, newError(msgOptionValue, "format", format)
+}
+
+// readSchedule returns the programmes of the lines, sorted by channel and start
+func (c *epgConfigT) readSchedule(lines []lineT) (progs []epgProgT, errs []error) {
+	for i := range lines {
+		line := &lines[i]
+		p := epgProgT{line: line, channel: strings.TrimSpace(line.fields[c.channelField])}
+		var errS, errE error
+		if p.start, errS = parseEPGTime(line.fields[c.startField], c.layout, c.loc); errS != nil {
+			errs = append(errs, &cellErrorT{err: errS, name: "programme", function: "epg", line: line, header: c.startField})
+		}
+		if p.end, errE = parseEPGTime(line.fields[c.endField], c.layout, c.loc); errE != nil {
+			errs = append(errs, &cellErrorT{err: errE, name: "programme", function: "epg", line: line, header: c.endField})
+		}
+		if errS != nil || errE != nil {
+			continue
+		}
+		if !p.end.After(p.start) {
+			errs = append(errs, &cellErrorT{err: newError(msgEPGEndBeforeStart, line.fields[c.endField],
+				line.fields[c.startField]), name: "programme", function: "epg", line: line, header: c.endField})
+			continue
+		}
+		progs = append(progs, p)
+	}
+	sort.SliceStable(progs, func(i, j int) bool {
+		if progs[i].channel != progs[j].channel {
+			return progs[i].channel < progs[j].channel
+		}
+		return progs[i].start.Before(progs[j].start)
+	})
+	return
+}
+
+// checkSchedule returns the overlaps and, if 'epg_max_gap' is set, the gaps greater than it between the
+// programmes of each channel
+func (c *epgConfigT) checkSchedule(progs []epgProgT) (errs []error) {
+	for i := 1; i < len(progs); i++ {
+		prev, p := progs[i-1], progs[i]
+		if prev.channel != p.channel {
+			continue
+		}
+		switch gap := p.start.Sub(prev.end); {
+		case gap < 0:
+			errs = append(errs, &cellErrorT{err: newError(msgEPGOverlap, p.channel, prev.line.idx,
+				prev.end.Format(c.layout)), name: "programme", function: "epg", line: p.line, header: c.startField})
+		case c.checkGaps && gap > c.maxGap:
+			errs = append(errs, &cellErrorT{err: newError(msgEPGGap, p.channel, gap.String(), prev.line.idx),
+				name: "programme", function: "epg", line: p.line, header: c.startField})
+		}
+	}
+	return
+}
+
+// epgSection returns a map of the config
+func epgSection(json jsonT, keys ...string) (jsonT, error) {
+	el := json
+	for _, k := range keys {
+		m, ok := el[k].(map[string]interface{})
+		if !ok {
+			return nil, newError(msgEPGSection, strings.Join(keys, "."))
+		}
+		el = m
+	}
+	return el, nil
+}
+
+// processEPG writes the schedule of the sheet 'programacao' as XMLTV and as the Box EPG files
+// (assets.json and broadcasts.json). The config maps each programme with the sections 'xmltv'
+// (channel and programme) and 'box_epg' (assets and broadcasts)
+func processEPG(run *runT, json jsonT, outDir string, lines []lineT) (success int, errs []error) {
+	c, err := newEPGConfig(run.options)
+	if err != nil {
+		return 2, []error{err}
+	}
+	sections := make(map[string]jsonT)
+	for _, keys := range [][]string{{"xmltv", "channel"}, {"xmltv", "programme"}, {"box_epg", "assets"},
+		{"box_epg", "broadcasts"}} {
+		if sections[keys[1]], err = epgSection(json, keys...); err != nil {
+			return 2, []error{err}
+		}
+	}
+	rules, err := readRules(json)
+	if err != nil {
+		return -1, []error{err}
+	}
+	log("------------------------------")
+	log(msg(msgGenerating))
+	log("------------------------------")
+	progs, errs := c.readSchedule(lines)
+	errs = append(errs, c.checkSchedule(progs)...)
+	var ruleErrs []error
+	for i := range progs {
+		ruleErrs = append(ruleErrs, checkRules(rules, []lineT{*progs[i].line})...)
+	}
+	xmlWr, err := newXMLWriter(run, path.Join(outDir, c.file), "")
+	if err != nil {
+		return 2, []error{err}
+	}
+	if err = xmlWr.OpenOutput(); err != nil {
+		return -1, []error{err}
+	}
+	if err = xmlWr.StartElem("tv", mapT); err != nil {
+		return -1, []error{err}
+	}
+	// channels come before the programmes in XMLTV
+	for i, p := range progs {
+		if i == 0 || p.channel != progs[i-1].channel {
+			log(msg(msgEPGChannel, p.channel))
+			run.packCount++
+			errs = append(errs, processMap(run, sections["channel"], []lineT{*p.line}, xmlWr)...)
+		}
+	}
+	assets := make([]interface{}, 0)
+	broadcasts := make([]interface{}, 0)
+	assetIDs := make(map[string]bool)
+	for _, p := range progs {
+		log(msg(msgProcessingLine, p.line.idx))
+		pack := []lineT{*p.line}
+		errs = append(errs, processMap(run, sections["programme"], pack, xmlWr)...)
+		for _, name := range []string{"assets", "broadcasts"} {
+			jsonWr, _ := newJSONWriter(run, "", nil, nil, assetsT)
+			errs = append(errs, processMap(run, sections[name], pack, jsonWr)...)
+			root, _ := jsonWr.root.(map[string]interface{})
+			items, _ := root[name].([]interface{})
+			for _, item := range items {
+				if name == "broadcasts" {
+					broadcasts = append(broadcasts, item)
+					continue
+				}
+				// reruns of a programme share the asset
+				id, _ := item.(map[string]interface{})["id"].(string)
+				if !assetIDs[id] {
+					assetIDs[id] = true
+					assets = append(assets, item)
+				}
+			}
+		}
+	}
+	if err = xmlWr.EndElem("tv", mapT); err != nil {
+		return -1, []error{err}
+	}
+	for _, e := range errs {
+		logError(e)
+	}
+	run.reportErrors(errs...)
+	run.reportErrors(ruleErrs...)
+	suffix := ""
+	if logRuleErrors(ruleErrs) || len(errs) > 0 {
+		success, suffix = -1, errSuffix
+	}
+	// Remove previous files
+	for _, f := range []string{xmlWr.Filename() + xmlWr.Suffix(), path.Join(outDir, boxAssetsFile),
+		path.Join(outDir, boxBroadcastsFile)} {
+		_ = os.Remove(f)
+		_ = os.Remove(errorFilename(f))
+	}
+	xmlFile := xmlWr.Filename() + suffix + xmlWr.Suffix()
+	if err = xmlWr.WriteAndClose(xmlFile); err != nil {
+		return -1, []error{err}
+	}
+	log(msg(msgSaving, xmlFile))
+	// an invalid guide is not delivered: its files get the error suffix, like those of the other errors
+	vErrs := validateXML(xmlWr.getBuffer())
+	if len(vErrs) > 0 && suffix == "" {
+		success, suffix = -1, errSuffix
+		if !xmlWr.Testing() {
+			if err = run.renameOutput(xmlFile, errorFilename(xmlFile)); err != nil {
+				return -1, append(vErrs, err)
+			}
+		}
+		xmlFile = errorFilename(xmlFile)
+	}
+	boxFiles := []struct {
+		name string
+		doc  map[string]interface{}
+	}{
+		{boxAssetsFile, map[string]interface{}{"assets": assets}},
+		{boxBroadcastsFile, map[string]interface{}{"broadcasts": broadcasts}},
+	}
+	for _, f := range boxFiles {
+		if err = writeEPGJSON(run, path.Join(outDir, f.name), suffix, f.doc); err != nil {
+			return -1, []error{err}
+		}
+	}
+	failed := make(map[int]bool)
+	for _, e := range append(errs, ruleErrs...) {
+		if ce, ok := e.(*cellErrorT); ok && ce.line != nil && !isWarning(e) {
+			failed[ce.line.idx] = true
+		}
+	}
+	for i := range lines {
+		run.setRowStatus(lines[i:i+1], path.Base(xmlFile), len(vErrs) == 0 && !failed[lines[i].idx])
+	}
+	return success, vErrs
+}
+
+// writeEPGJSON writes a Box EPG file
+func writeEPGJSON(run *runT, filename string, suffix string, doc map[string]interface{}) error {
+	buf, err := js.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	if suffix != "" {
+		filename = errorFilename(filename)
+	}
+	log(msg(msgSaving, filename))
+	if err = ioutil.WriteFile(filename, buf, 0644); err != nil {
+		return newError(msgCreateFile, filename, err)
+	}
+	run.registerOutput(filename)
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	js "encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Error report file names, written in the output directory
+const (
+	errorReportJSON = "erros.json"
+	errorReportCSV  = "erros.csv"
+)
+
+// cellErrorT is an error raised by a config function while processing a spreadsheet line
+type cellErrorT struct {
+	err      error
+	path     []string    // config elements enclosing the element, outermost first
+	name     interface{} // config element name
+	function string
+	line     *lineT
+	header   string // column read by the function, lowercase
+	severity string // severityError (default) or severityWarning
+}
+
+// newCellError wraps an error returned by a config function, recording where it happened
+func newCellError(err error, line *lineT, json jsonT, function string) *cellErrorT {
+	header, _ := json["field"].(string)
+	if header == "" {
+		header, _ = json["field1"].(string)
+	}
+	return &cellErrorT{
+		err:      err,
+		name:     json["Name"],
+		function: function,
+		line:     line,
+		header:   strings.ToLower(header),
+	}
+}
+
+func (e *cellErrorT) Error() string {
+	var b strings.Builder
+	for _, p := range e.path {
+		b.WriteString(fmt.Sprintf("[%s] ", p))
+	}
+	b.WriteString(fmt.Sprintf("[%s]: %v", e.name, e.err.Error()))
+	return b.String()
+}
+
+// Unwrap returns the original error
+func (e *cellErrorT) Unwrap() error {
+	return e.err
+}
+
+// conversionErrorT is raised by a writer when a value can't be converted to the type of the element
+type conversionErrorT struct {
+	name  string // element name
+	value string
+	vtype string
+	err   error // cause, may be nil
+}
+
+// newConversionError creates a conversion error for the value of an element
+func newConversionError(name string, value string, vtype string, err error) *conversionErrorT {
+	return &conversionErrorT{name: name, value: value, vtype: vtype, err: err}
+}
+
+func (e *conversionErrorT) Error() string {
+	s := msg(msgConversionFailed, e.name, e.vtype, e.value)
+	if e.err != nil {
+		s += fmt.Sprintf(" (%v)", e.err)
+	}
+	return s
+}
+
+// Code returns the message code of the conversion error
+func (e *conversionErrorT) Code() string {
+	return msgConversionFailed
+}
+
+// Unwrap returns the cause of the conversion failure
+func (e *conversionErrorT) Unwrap() error {
+	return e.err
+}
+
+// errorRecordT is one line of the error report
+type errorRecordT struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Sheet    string `json:"sheet"`
+	Row      int    `json:"row"`
+	Column   string `json:"column"`
+	Header   string `json:"header"`
+	Element  string `json:"element"`
+	Function string `json:"function"`
+	Value    string `json:"value"`
+	Message  string `json:"message"`
+}
+
+// newErrorRecord converts an error to a report record. Errors without coordinates only have a message
+func newErrorRecord(err error) errorRecordT {
+	cellErr, ok := err.(*cellErrorT)
+	if !ok {
+		return errorRecordT{Severity: severityError, Code: errorCode(err), Message: err.Error()}
+	}
+	element := strings.Join(append(append([]string{}, cellErr.path...), fmt.Sprint(cellErr.name)), "/")
+	rec := errorRecordT{
+		Severity: severityError,
+		Code:     errorCode(cellErr.err),
+		Header:   cellErr.header,
+		Element:  element,
+		Function: cellErr.function,
+		Message:  cellErr.err.Error(),
+	}
+	if cellErr.severity != "" {
+		rec.Severity = cellErr.severity
+	}
+	if line := cellErr.line; line != nil {
+		rec.Row = line.idx
+		rec.Value = line.fields[cellErr.header]
+		if line.sheet != nil {
+			rec.Sheet = line.sheet.name
+			rec.Column = line.sheet.columnLetter(cellErr.header)
+		}
+	}
+	return rec
+}
+
+// reportErrors adds errors to the error report
+func (r *runT) reportErrors(errs ...error) {
+	for _, e := range errs {
+		if e != nil {
+			r.errorRecords = append(r.errorRecords, newErrorRecord(e))
+		}
+	}
+}
+
+// errorCount returns the number of reported errors, not counting warnings
+func (r *runT) errorCount() (n int) {
+	for _, rec := range r.errorRecords {
+		if rec.Severity != severityWarning {
+			n++
+		}
+	}
+	return
+}
+
+// writeErrorReport writes the error report as JSON and CSV. Without errors, removes previous reports
+func (r *runT) writeErrorReport(outDir string) error {
+	fileJSON := path.Join(outDir, errorReportJSON)
+	fileCSV := path.Join(outDir, errorReportCSV)
+	if len(r.errorRecords) == 0 {
+		_ = os.Remove(fileJSON)
+		_ = os.Remove(fileCSV)
+		return nil
+	}
+	bufJSON, err := js.MarshalIndent(r.errorRecords, "", "  ")
+	if err != nil {
+		return err
+	}
+	bufCSV, err := errorReportCSVBytes(r.errorRecords)
+	if err != nil {
+		return err
+	}
+	log(msg(msgSaving, fileJSON))
+	if err = ioutil.WriteFile(fileJSON, bufJSON, 0644); err != nil {
+		return newError(msgCreateFile, fileJSON, err)
+	}
+	log(msg(msgSaving, fileCSV))
+	if err = ioutil.WriteFile(fileCSV, bufCSV, 0644); err != nil {
+		return newError(msgCreateFile, fileCSV, err)
+	}
+	return nil
+}
+
+// errorReportCSVBytes formats the records as CSV, with a header line
+func errorReportCSVBytes(records []errorRecordT) ([]byte, error) {
+	b := &bytes.Buffer{}
+	w := csv.NewWriter(b)
+	if err := w.Write([]string{"severity", "code", "sheet", "row", "column", "header", "element", "function", "value", "message"}); err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		row := ""
+		if r.Row > 0 {
+			row = strconv.Itoa(r.Row)
+		}
+		if err := w.Write([]string{r.Severity, r.Code, r.Sheet, row, r.Column, r.Header, r.Element, r.Function, r.Value, r.Message}); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+// columnLetter returns the spreadsheet column ("A", "B", ..., "AA") of a header, or "" if not found
+func (s *sheetInfoT) columnLetter(header string) string {
+	for i, h := range s.header {
+		if h == header {
+			return columnName(i)
+		}
+	}
+	return ""
+}
+
+// columnName converts a 0-based column index to its spreadsheet name
+func columnName(idx int) string {
+	name := ""
+	for idx >= 0 {
+		name = string(rune('A'+idx%26)) + name
+		idx = idx/26 - 1
+	}
+	return name
+}
@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"github.com/Knetic/govaluate"
 	"path"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -38,12 +40,18 @@ func newResultVars(val string, key string, value string) resultsT {
 
 var errorMessage = []resultsT{newResult("#ERRO#")}
 
+// userFunctionT is a function of the config
+type userFunctionT func(string, *lineT, jsonT, optionsT) ([]resultsT, error)
+
 // FunctionDict is the relation between the operation name and the function
-var functionDict map[string]func(string, *lineT, jsonT, optionsT) ([]resultsT, error)
+var functionDict map[string]userFunctionT
+
+// functionsOnce initializes functionDict for all the runs
+var functionsOnce sync.Once
 
 // InitFunctions maps the user functions
 func initFunctions() {
-	functionDict = map[string]func(string, *lineT, jsonT, optionsT) ([]resultsT, error){
+	functionDict = map[string]userFunctionT{
 		"assetid":             assetID,
 		"assetid_ott":         assetIDOtt,
 		"attr_map":            attrMap,
@@ -55,6 +63,7 @@ func initFunctions() {
 		"date_ott":            dateRFC3339,
 		"empty":               emptyFunc,
 		"episode_id":          episodeID,
+		"epg_time":            epgTime,
 		"eval":                eval,
 		"field":               fieldTrunc,
 		"field_date":          fieldDate,
@@ -72,6 +81,11 @@ func initFunctions() {
 		"janela_repasse":      janelaRepasse,
 		"last_name":           lastName,
 		"map":                 mapField,
+		"media_height":        mediaHeight,
+		"media_md5":           mediaMD5,
+		"media_resolution":    mediaResolution,
+		"media_size":          mediaSize,
+		"media_width":         mediaWidth,
 		"middle_name":         middleName,
 		"option":              option,
 		"seconds":             seconds,
@@ -90,23 +104,23 @@ func initFunctions() {
 }
 
 // Process process one element from json config
-func process(funcName string, lines []lineT, json jsonT, options optionsT) ([]resultsT, error) {
+func process(run *runT, funcName string, lines []lineT, json jsonT) ([]resultsT, error) {
+	options := run.options
 	// fmt.Printf("=> %s\n", funcName)
 	if funcName == "" {
-		return errorMessage, fmt.Errorf("'function' nao especificada")
+		return errorMessage, newError(msgFunctionUnspecified)
 	}
-	function, ok := functionDict[funcName]
+	function, ok := run.function(funcName)
 	if !ok {
-		fmt.Printf("Warning: funcao [%s] nao existe!\n", funcName)
+		fmt.Println(msg(msgFunctionMissing, funcName))
 		result, _ := undefined("", nil, json, options)
-		return result, fmt.Errorf("funcao nao definida: [%s]", funcName)
+		return result, newError(msgFunctionNotDefined, funcName)
 	}
 	result := make([]resultsT, 0)
 	for _, line := range lines {
 		res, err := function("", &line, json, options)
 		if err != nil {
-			name, _ := json["Name"]
-			return res, fmt.Errorf("[%s]: %v", name, err.Error())
+			return res, newCellError(err, &line, json, funcName)
 		}
 		result = append(result, res...)
 	}
@@ -129,13 +143,15 @@ func fixed(forceVal string, _ *lineT, json jsonT, _ optionsT) ([]resultsT, error
 	return []resultsT{newResult(val)}, err
 }
 
-// FieldMoney returns field formatted as money
+// FieldMoney returns field formatted as money, using the element 'money_format' and the options
+// 'money_locale' (default: the locale of the currency) and 'currency'
 func fieldMoney(forceVal string, line *lineT, json jsonT, options optionsT) ([]resultsT, error) {
 	val, errF := getField(forceVal, "", line, json, options)
 	if errF != nil {
 		return errorMessage, errF
 	}
-	result, errM := formatMoney(val)
+	format, _ := json["money_format"].(string)
+	result, errM := formatMoneyLocale(val, format, moneyLocale(options), options["options"]["currency"])
 	if errM != nil {
 		return errorMessage, errM
 	}
@@ -167,7 +183,7 @@ func fieldDate(forceVal string, line *lineT, json jsonT, options optionsT) ([]re
 	t, errD := parseDate(value)
 	if errD != nil {
 		fieldName, _ := getValue("field", json)
-		return errorMessage, fmt.Errorf("erro no campo '%s': [%s] na linha %d", fieldName, errD.Error(), line.idx)
+		return errorMessage, newError(msgFieldError, fieldName, errD.Error(), line.idx)
 	}
 	return []resultsT{newResult(formatDate(t))}, errD
 }
@@ -189,7 +205,7 @@ func getField(forceVal string, fieldN string, line *lineT, json jsonT, _ options
 	fieldName = strings.ToLower(fieldName)
 	value, ok := line.fields[fieldName]
 	if !ok {
-		return fieldName, fmt.Errorf("elemento '%s' inexistente na linha %d", fieldName, line.idx)
+		return fieldName, newError(msgElementNotInLine, fieldName, line.idx)
 	}
 	// fmt.Printf("field(%v) = [%v]\n", field, value)
 	return value, nil
@@ -211,8 +227,7 @@ func fieldValidated(forceVal string, line *lineT, json jsonT, options optionsT)
 			return f, nil
 		}
 	}
-	return errorMessage, fmt.Errorf("falha na validacao do elemento '%s': [%v], "+
-		"valores possiveis: %v na linha %d", json["Name"], f[0].val, opts, line.idx)
+	return errorMessage, newError(msgValidationFailed, json["Name"], f[0].val, opts, line.idx)
 }
 
 // FieldNoAccents returns the field after replacing accented characters for its non-accented correspondents
@@ -269,9 +284,9 @@ func suffix(forceVal string, line *lineT, json jsonT, options optionsT) ([]resul
 		// have size limit: truncate string
 		max, errAt := strconv.Atoi(maxS)
 		if errAt != nil {
-			return errorMessage, fmt.Errorf("valor nao numerico em maxlenght: [%v]", maxS)
+			return errorMessage, newError(msgMaxLengthNotNumeric, maxS)
 		}
-		middle, errAt = truncateSuffix(result, suf, max)
+		middle, errAt = truncateSuffix(result, suf, max, options)
 		if errAt != nil {
 			return errorMessage, errAt
 		}
@@ -322,9 +337,9 @@ func pathSuffix(forceVal string, line *lineT, json jsonT, options optionsT) ([]r
 		// have size limit: truncate string
 		max, errAt := strconv.Atoi(maxS)
 		if errAt != nil {
-			return errorMessage, fmt.Errorf("valor nao numerico em maxlenght: [%v]", maxS)
+			return errorMessage, newError(msgMaxLengthNotNumeric, maxS)
 		}
-		middle, errAt = truncateSuffix(alpha, suf, max)
+		middle, errAt = truncateSuffix(alpha, suf, max, options)
 		if errAt != nil {
 			return errorMessage, errAt
 		}
@@ -345,7 +360,7 @@ func locationSerie(forceVal string, line *lineT, json jsonT, options optionsT) (
 
 	fieldDir, okfd := json["fieldDir"].(string)
 	if !okfd {
-		return errorMessage, fmt.Errorf("campo fieldDir faltando: [%v]", json)
+		return errorMessage, newError(msgFieldDirMissing, json)
 	}
 	fDir, _ := line.fields[strings.ToLower(fieldDir)]
 	fD, errfd := removeAccents(fDir)
@@ -387,7 +402,7 @@ func locationSerie(forceVal string, line *lineT, json jsonT, options optionsT) (
 
 	serie1, _ := line.fields["título original"]
 	if serie1 == "" {
-		return errorMessage, fmt.Errorf("titulo original nao informado: [%v]", line.fields)
+		return errorMessage, newError(msgOriginalTitleMissing, line.fields)
 	}
 	serie2, errs1 := removeAccents(serie1)
 	if errs1 != nil {
@@ -401,7 +416,7 @@ func locationSerie(forceVal string, line *lineT, json jsonT, options optionsT) (
 
 	temp, _ := line.fields["temporada"]
 	if temp == "" {
-		return errorMessage, fmt.Errorf("temporada nao informada: [%s]", line.fields["título original"])
+		return errorMessage, newError(msgSeasonMissing, line.fields["título original"])
 	}
 	temp, err := formatNumberString(temp)
 	if err != nil {
@@ -409,7 +424,7 @@ func locationSerie(forceVal string, line *lineT, json jsonT, options optionsT) (
 	}
 	epi, _ := line.fields["número do episódio"]
 	if epi == "" {
-		return errorMessage, fmt.Errorf("numero do episodio nao informado: [%s]", line.fields["título original"])
+		return errorMessage, newError(msgEpisodeMissing, line.fields["título original"])
 	}
 	epi, err = formatNumberString(epi)
 	if err != nil {
@@ -426,9 +441,9 @@ func locationSerie(forceVal string, line *lineT, json jsonT, options optionsT) (
 		// have size limit: truncate string
 		max, errAt := strconv.Atoi(maxS)
 		if errAt != nil {
-			return errorMessage, fmt.Errorf("valor nao numerico em maxlenght: [%v]", maxS)
+			return errorMessage, newError(msgMaxLengthNotNumeric, maxS)
 		}
-		middle, errAt = truncateSuffix(alpha, suf, max)
+		middle, errAt = truncateSuffix(alpha, suf, max, options)
 		if errAt != nil {
 			return errorMessage, errAt
 		}
@@ -478,13 +493,13 @@ func locationSerieBox(forceVal string, line *lineT, json jsonT, options optionsT
 		// if location fiels exists, returs concatenations of id and location
 		id, oki := line.fields["id"]
 		if !oki {
-			return errorMessage, fmt.Errorf("id nao informado: [%v] na linha %d", line.fields, line.idx)
+			return errorMessage, newError(msgIdMissing, line.fields, line.idx)
 		}
 
 		res := fmt.Sprintf("%s/%s", location, id)
 		reg, err := regexp.Compile("/+")
 		if err != nil {
-			return errorMessage, fmt.Errorf("erro inesperado na linha %d", line.idx)
+			return errorMessage, newError(msgUnexpectedError, line.idx)
 		}
 		result := reg.ReplaceAllString(res, "/")
 
@@ -493,7 +508,7 @@ func locationSerieBox(forceVal string, line *lineT, json jsonT, options optionsT
 
 	serie1, _ := line.fields["título original"]
 	if serie1 == "" {
-		return errorMessage, fmt.Errorf("titulo original nao informado: [%v]", line.fields)
+		return errorMessage, newError(msgOriginalTitleMissing, line.fields)
 	}
 	serie2, errs1 := removeAccents(serie1)
 	if errs1 != nil {
@@ -507,7 +522,7 @@ func locationSerieBox(forceVal string, line *lineT, json jsonT, options optionsT
 
 	temp, _ := line.fields["temporada"]
 	if temp == "" {
-		return errorMessage, fmt.Errorf("temporada nao informada: [%s]", line.fields["título original"])
+		return errorMessage, newError(msgSeasonMissing, line.fields["título original"])
 	}
 	temp, err := formatNumberString(temp)
 	if err != nil {
@@ -515,7 +530,7 @@ func locationSerieBox(forceVal string, line *lineT, json jsonT, options optionsT
 	}
 	epi, _ := line.fields["número do episódio"]
 	if epi == "" {
-		return errorMessage, fmt.Errorf("numero do episodio nao informado: [%s]", line.fields["título original"])
+		return errorMessage, newError(msgEpisodeMissing, line.fields["título original"])
 	}
 	epi, err = formatNumberString(epi)
 	if err != nil {
@@ -532,9 +547,9 @@ func locationSerieBox(forceVal string, line *lineT, json jsonT, options optionsT
 		// have size limit: truncate string
 		max, errAt := strconv.Atoi(maxS)
 		if errAt != nil {
-			return errorMessage, fmt.Errorf("valor nao numerico em maxlenght: [%v]", maxS)
+			return errorMessage, newError(msgMaxLengthNotNumeric, maxS)
 		}
-		middle, errAt = truncateSuffix(alpha, suf, max)
+		middle, errAt = truncateSuffix(alpha, suf, max, options)
 		if errAt != nil {
 			return errorMessage, errAt
 		}
@@ -555,24 +570,24 @@ func assetID(forceVal string, line *lineT, json jsonT, options optionsT) ([]resu
 	}
 	fProvider, ok := json["prefix"].(string)
 	if !ok || fProvider == "" {
-		return errorMessage, fmt.Errorf("field prefixo do Asset ID nao encontrado (prefix): [%v]", json)
+		return errorMessage, newError(msgAssetPrefixMissing, json)
 	}
 	fProvider = strings.ToLower(fProvider)
 	prov, okP := line.fields[fProvider]
 	if !okP || prov == "" {
-		return errorMessage, fmt.Errorf("provider assetid nao encontrado (provider): [%v] na linha %d", line.fields, line.idx)
+		return errorMessage, newError(msgProviderMissing, line.fields, line.idx)
 	}
 	suffixF, okS := json["suffix_number"].(float64)
 	if !okS {
-		return errorMessage, fmt.Errorf("numero do sufixo do assetid (suffix_number) nao encontrado: [%v]", json)
+		return errorMessage, newError(msgSuffixNumberMissing, json)
 	}
 	timest := options["options"]["timestamp"]
 	if !ok || timest == "" {
-		return errorMessage, fmt.Errorf("timestamp nao encontrada (timestamp): [%v]", options)
+		return errorMessage, newError(msgTimestampMissing, options)
 	}
 	fileNum, okN := line.fields["file_number"]
 	if !okN || fileNum == "" {
-		return errorMessage, fmt.Errorf("numero do arquivo nao encontrado (file_number): [%v] na linha %d", line.fields, line.idx)
+		return errorMessage, newError(msgFileNumberMissing, line.fields, line.idx)
 	}
 	result := buildAssetID(prov, suffixF, timest, fileNum)
 	return []resultsT{newResult(result)}, nil
@@ -599,11 +614,11 @@ func assetIDOtt(forceVal string, line *lineT, _ jsonT, options optionsT) ([]resu
 	}
 	timest, okT := options["options"]["timestamp"]
 	if !okT || timest == "" {
-		return errorMessage, fmt.Errorf("timestamp nao encontrada (timestamp): [%v]", options)
+		return errorMessage, newError(msgTimestampMissing, options)
 	}
 	fileNum, okN := line.fields["file_number"]
 	if !okN || fileNum == "" {
-		return errorMessage, fmt.Errorf("numero do arquivo nao encontrado (file_number): [%v] na linha %d", line.fields, line.idx)
+		return errorMessage, newError(msgFileNumberMissing, line.fields, line.idx)
 	}
 	result := fmt.Sprintf("%s%03s", timest, fileNum)
 	return []resultsT{newResult(result)}, nil
@@ -616,21 +631,21 @@ func episodeID(forceVal string, line *lineT, _ jsonT, options optionsT) ([]resul
 	}
 	fSeason, okF := options["options"]["season_field"]
 	if !okF || fSeason == "" {
-		return errorMessage, fmt.Errorf("config para campo 'season_field' nao encontrado: [%v]", options)
+		return errorMessage, newError(msgSeasonFieldConfig, options)
 	}
 	fSeason = strings.ToLower(fSeason)
 	season, okS := line.fields[fSeason]
 	if !okS || season == "" {
-		return errorMessage, fmt.Errorf("temporada do episode_id nao encontrada (%v): [%v]", fSeason, line)
+		return errorMessage, newError(msgEpisodeSeasonMissing, fSeason, line)
 	}
 	fEpisodeID, okEid := options["options"]["episode_field"]
 	if !okEid || fEpisodeID == "" {
-		return errorMessage, fmt.Errorf("config para campo 'episode_field' nao encontrado: [%v]", options)
+		return errorMessage, newError(msgEpisodeFieldConfig, options)
 	}
 	fEpisodeID = strings.ToLower(fEpisodeID)
 	episode, okE := line.fields[fEpisodeID]
 	if !okE || season == "" {
-		return errorMessage, fmt.Errorf("valor do episode_id nao encontrado (%v): [%v] na linha %d", fEpisodeID, line.fields, line.idx)
+		return errorMessage, newError(msgEpisodeIdMissing, fEpisodeID, line.fields, line.idx)
 	}
 	result := fmt.Sprintf("%02s%03s", season, episode)
 	return []resultsT{newResult(result)}, nil
@@ -640,17 +655,17 @@ func findInSerieMap(str1 string, str2 string, sData map[string]string) (string,
 	for k, v := range sData {
 		names := strings.Split(k, "|")
 		if len(names) != 2 {
-			return "", "", fmt.Errorf("dados da serie invalidos: [%v]", k)
+			return "", "", newError(msgInvalidSeriesData, k)
 		}
 		if names[0] == str1 && names[1] == str2 {
 			ids := strings.Split(v, "|")
 			if len(ids) != 2 {
-				return "", "", fmt.Errorf("dados da serie invalidos: [%v]", v)
+				return "", "", newError(msgInvalidSeriesData, v)
 			}
 			return ids[0], ids[1], nil
 		}
 	}
-	return "", "", fmt.Errorf("serie nao encontrada, adicione na aba series: [%s], temporada [%s]", str1, str2)
+	return "", "", newError(msgSeriesNotFound, str1, str2)
 }
 
 // seriesId returns the serie Id from sheet "series"
@@ -683,15 +698,15 @@ func getSeries(forceVal string, line *lineT, json jsonT, options optionsT) (stri
 	}
 	seasonS, okN := line.fields["temporada"] // TODO move to config
 	if !okN {
-		return "", "", errorMessage, fmt.Errorf("campo 'Temporada' nao encontrado: [%s]", line.fields["título original"]), true
+		return "", "", errorMessage, newError(msgSeasonFieldMissing, line.fields["título original"]), true
 	}
 	fSeries, okF := options["options"]["series_id_field"]
 	if !okF || fSeries == "" {
-		return "", "", errorMessage, fmt.Errorf("config para campo 'series_id_field' nao encontrado: [%v]", options), true
+		return "", "", errorMessage, newError(msgSeriesIdFieldConfig, options), true
 	}
 	seriesData, okO := options["series"]
 	if !okO {
-		return "", "", errorMessage, fmt.Errorf("nao ha' dados na aba 'series'"), true
+		return "", "", errorMessage, newError(msgNoSeriesData), true
 	}
 	idSeries, idSeason, err := findInSerieMap(titleA, seasonS, seriesData)
 	if err != nil {
@@ -721,7 +736,7 @@ func emptyFunc(_ string, _ *lineT, _ jsonT, _ optionsT) ([]resultsT, error) {
 func setVar(value string, _ *lineT, json jsonT, _ optionsT) ([]resultsT, error) {
 	name, ok := json["var"].(string)
 	if !ok || name == "" {
-		return errorMessage, fmt.Errorf("campo 'var' nao encontrado: [%v]", json)
+		return errorMessage, newError(msgVarNotFound, json)
 	}
 	return []resultsT{newResultVars("", "$"+name, value)}, nil
 }
@@ -734,7 +749,7 @@ func convertDate(value string, line *lineT, json jsonT, options optionsT) ([]res
 	}
 	t, errP := time.Parse("01/02/06", field[0].val)
 	if errP != nil {
-		return errorMessage, fmt.Errorf("erro no formato da data: [%v] na linha %d", errP.Error(), line.idx)
+		return errorMessage, newError(msgDateFormat, errP.Error(), line.idx)
 	}
 	return []resultsT{newResult(formatDate(t))}, nil
 }
@@ -748,7 +763,7 @@ func dateRFC3339(forceVal string, line *lineT, json jsonT, options optionsT) ([]
 	val := field[0].val
 	t, errP := time.Parse("01-02-06", val)
 	if errP != nil {
-		return errorMessage, fmt.Errorf("erro no formato da data: [%v] na linha %d", errP.Error(), line.idx)
+		return errorMessage, newError(msgDateFormat, errP.Error(), line.idx)
 	}
 	return []resultsT{newResult(t.Format(time.RFC3339))}, nil
 }
@@ -759,17 +774,17 @@ func condition(forceValue string, line *lineT, json jsonT, _ optionsT) ([]result
 	var ok bool
 	var trueVal string
 	if trueVal, ok = json["if_true"].(string); !ok {
-		return errorMessage, fmt.Errorf("elemento '%s' inexistente na condicao [%v]", "if_true", json)
+		return errorMessage, newError(msgConditionElement, "if_true", json)
 	}
 	var falseVal string
 	falseVal, ok = json["if_false"].(string)
 	if !ok {
-		return errorMessage, fmt.Errorf("elemento '%s' inexistente na condicao [%v]", "if_false", json)
+		return errorMessage, newError(msgConditionElement, "if_false", json)
 	}
 	if forceValue == "" {
 		cond, ok = json["condition"].(string)
 		if !ok {
-			return errorMessage, fmt.Errorf("elemento '%s' inexistente na condicao [%v]", "condition", json)
+			return errorMessage, newError(msgConditionElement, "condition", json)
 		}
 	} else {
 		cond = forceValue
@@ -784,6 +799,83 @@ func condition(forceValue string, line *lineT, json jsonT, _ optionsT) ([]result
 	}
 }
 
+// exprFunctions are the functions available in eval, filter and condition expressions
+var exprFunctions = map[string]govaluate.ExpressionFunction{
+	"strlen": func(args ...interface{}) (interface{}, error) {
+		length := len(args[0].(string))
+		return fmt.Sprintf("%d", length), nil
+	},
+	"replace": func(args ...interface{}) (interface{}, error) {
+		orig := args[0].(string)
+		from := args[1].(string)
+		to := args[2].(string)
+		return strings.ReplaceAll(orig, from, to), nil
+	},
+	// date converts a date read from the spreadsheet to a number, for comparisons. Empty dates are 0
+	"date": func(args ...interface{}) (interface{}, error) {
+		val := strings.TrimSpace(fmt.Sprint(args[0]))
+		if val == "" {
+			return 0.0, nil
+		}
+		for _, layout := range []string{dateformat, "02/01/2006", "2006-01-02"} {
+			if t, err := time.Parse(layout, val); err == nil {
+				return float64(t.Unix()), nil
+			}
+		}
+		return nil, newError(msgInvalidDate, val)
+	},
+}
+
+// exprFunctionArgs is the number of arguments of each function of exprFunctions
+var exprFunctionArgs = map[string]int{"strlen": 1, "replace": 3, "date": 1}
+
+// newExpression parses an expression, verifying the number of arguments of the calls to exprFunctions,
+// which read their arguments without checking them
+func newExpression(expr string) (*govaluate.EvaluableExpression, error) {
+	expression, err := govaluate.NewEvaluableExpressionWithFunctions(expr, exprFunctions)
+	if err != nil {
+		return nil, err
+	}
+	// the tokens hold the functions, not their names
+	names := make(map[uintptr]string, len(exprFunctions))
+	for name, f := range exprFunctions {
+		names[reflect.ValueOf(f).Pointer()] = name
+	}
+	tokens := expression.Tokens()
+	for i, tk := range tokens {
+		if tk.Kind != govaluate.FUNCTION {
+			continue
+		}
+		name := names[reflect.ValueOf(tk.Value).Pointer()]
+		args, depth, empty := 1, 0, true
+	call:
+		for _, t := range tokens[i+1:] {
+			switch t.Kind {
+			case govaluate.CLAUSE:
+				if depth++; depth == 1 {
+					continue
+				}
+			case govaluate.CLAUSE_CLOSE:
+				if depth--; depth == 0 {
+					break call
+				}
+			case govaluate.SEPARATOR:
+				if depth == 1 {
+					args++
+				}
+			}
+			empty = false
+		}
+		if empty {
+			args = 0
+		}
+		if want, ok := exprFunctionArgs[name]; ok && args != want {
+			return nil, newError(msgExprFunctionArgs, name, expr, args, want)
+		}
+	}
+	return expression, nil
+}
+
 // Eval evaluates an expression
 func eval(value string, line *lineT, json jsonT, _ optionsT) ([]resultsT, error) {
 	var expr string
@@ -791,27 +883,18 @@ func eval(value string, line *lineT, json jsonT, _ optionsT) ([]resultsT, error)
 	if value == "" {
 		expr, ok = json["expression"].(string)
 		if !ok {
-			return errorMessage, fmt.Errorf("elemento '%s' inexistente na expressao [%v]", "expression", json)
+			return errorMessage, newError(msgExpressionElement, "expression", json)
 		}
 	} else {
 		expr = value
 	}
 	expr = strings.ToLower(expr)
-	functions := map[string]govaluate.ExpressionFunction{
-		"strlen": func(args ...interface{}) (interface{}, error) {
-			length := len(args[0].(string))
-			return fmt.Sprintf("%d", length), nil
-		},
-		"replace": func(args ...interface{}) (interface{}, error) {
-			orig := args[0].(string)
-			from := args[1].(string)
-			to := args[2].(string)
-			return strings.ReplaceAll(orig, from, to), nil
-		},
-	}
-	expression, errV := govaluate.NewEvaluableExpressionWithFunctions(expr, functions)
+	expression, errV := newExpression(expr)
 	if errV != nil {
-		return errorMessage, fmt.Errorf("expressao invalida (%v): [%v]", expr, json)
+		if errorCode(errV) != "" {
+			return errorMessage, errV
+		}
+		return errorMessage, newError(msgInvalidExpressionValue, expr, json)
 	}
 	params := make(map[string]interface{})
 	for k, v := range line.fields {
@@ -819,7 +902,7 @@ func eval(value string, line *lineT, json jsonT, _ optionsT) ([]resultsT, error)
 	}
 	result, errE := expression.Evaluate(params)
 	if errE != nil {
-		return errorMessage, fmt.Errorf("erro na expressao [%s] com parametros [%#v], [%v]", expr, params, json)
+		return errorMessage, newError(msgExpressionError, expr, params, json)
 	}
 	if result == nil {
 		result = ""
@@ -834,7 +917,7 @@ func filterCondition(forceVal string, line *lineT, json jsonT, options optionsT)
 	if forceVal == "" {
 		cond, ok = json["filter"].(string)
 		if !ok {
-			return errorMessage, fmt.Errorf("elemento '%s' inexistente na linha %v", "Value", json)
+			return errorMessage, newError(msgElementNotInLineV, "Value", json)
 		}
 	} else {
 		cond = forceVal
@@ -842,21 +925,21 @@ func filterCondition(forceVal string, line *lineT, json jsonT, options optionsT)
 	cond = strings.ToLower(cond)
 	condResult, err := evalCondition(cond, line)
 	if err != nil {
-		return errorMessage, fmt.Errorf("falha ao avaliar expressao [%s]: [%s] na linha %v", cond, err.Error(), line.idx)
+		return errorMessage, newError(msgExpressionEvalFailed, cond, err.Error(), line.idx)
 	}
 	if condResult {
 		funcName, ok1 := json["function"].(string)
 		if !ok1 {
-			return errorMessage, fmt.Errorf("condicao sem elemento 'function' na linha %v", json)
+			return errorMessage, newError(msgConditionNoFunction, json)
 		}
 		if funcName == "filter" {
-			return errorMessage, fmt.Errorf("condicao recursiva (elemento 'filter' + 'function = filter') na linha %v", json)
+			return errorMessage, newError(msgConditionRecursive, json)
 		}
 		function, ok2 := functionDict[funcName]
 		if !ok2 {
-			fmt.Printf("Warning: funcao [%s] nao existe!\n", funcName)
+			fmt.Println(msg(msgFunctionMissing, funcName))
 			result, _ := undefined("", nil, json, options)
-			return result, fmt.Errorf("funcao nao definida: [%s]", funcName)
+			return result, newError(msgFunctionNotDefined, funcName)
 		}
 		return function("", line, json, options)
 	}
@@ -867,11 +950,11 @@ func filterCondition(forceVal string, line *lineT, json jsonT, options optionsT)
 func split(forceVal string, line *lineT, json jsonT, options optionsT) ([]resultsT, error) {
 	funcName, okF := json["function2"].(string)
 	if !okF {
-		return errorMessage, fmt.Errorf("elemento [%s] inexistente na linha %v", "function2", line)
+		return errorMessage, newError(msgElementNotInLineB, "function2", line)
 	}
 	func2, okD := functionDict[funcName]
 	if !okD {
-		return errorMessage, fmt.Errorf("funcao2 '%s' invalida na linha", json)
+		return errorMessage, newError(msgInvalidFunction2, json)
 	}
 	var field string
 	var err error
@@ -879,7 +962,7 @@ func split(forceVal string, line *lineT, json jsonT, options optionsT) ([]result
 		var okV bool
 		field, okV = json["Value"].(string)
 		if !okV {
-			return errorMessage, fmt.Errorf("funcao fixed precisa de elemento 'value' na linha %v", line)
+			return errorMessage, newError(msgFixedNoValue, line)
 		}
 	} else {
 		field, err = getField(forceVal, "", line, json, options)
@@ -936,7 +1019,7 @@ func attrMap(forceVal string, line *lineT, json jsonT, options optionsT) ([]resu
 	//values := strings.Split(key, ",")
 	//attrs, ok := json["attrs"].([]interface{})
 	//if !ok {
-	//	return errorMessage, fmt.Errorf("atributo 'attrs' nao encontrado em funcao attrmap")
+	//	return errorMessage, newError(msgAttrmapNoAttrs)
 	//}
 	//var attrMap map[string]string
 	//for _, s := range values {
@@ -944,7 +1027,7 @@ func attrMap(forceVal string, line *lineT, json jsonT, options optionsT) ([]resu
 	//		attr := at.(map[string]interface{})
 	//		name, ok := attr["Name"].(string)
 	//		if !ok {
-	//			return errorMessage, fmt.Errorf("atributo 'Name' nao encontrado em funcao attrmap")
+	//			return errorMessage, newError(msgAttrmapNoName)
 	//		}
 	//		fun, err2 := getField(value, "attr_list", line, json, options)
 	//		if err2 != nil {
@@ -974,16 +1057,16 @@ func convert(forceVal string, line *lineT, json jsonT, options optionsT) ([]resu
 	}
 	from, okF := json["from"].(string)
 	if !okF {
-		return errorMessage, fmt.Errorf("elemento 'from' faltando com function 'convert': [%v]", json)
+		return errorMessage, newError(msgConvertNoFrom, json)
 	}
 	to, okT := json["to"].(string)
 	if !okT {
-		return errorMessage, fmt.Errorf("elemento 'to' faltando com function 'convert': [%v]", json)
+		return errorMessage, newError(msgConvertNoTo, json)
 	}
 	fArr := strings.Split(from, ",")
 	tArr := strings.Split(to, ",")
 	if len(fArr) == 0 || len(fArr) != len(tArr) {
-		return errorMessage, fmt.Errorf("funcao 'convert' tem que ter parametros 'from' e 'to' com mesmo numero de elementos")
+		return errorMessage, newError(msgConvertSizes)
 	}
 	cMap := make(map[string]string)
 	for i, fr := range fArr {
@@ -991,7 +1074,7 @@ func convert(forceVal string, line *lineT, json jsonT, options optionsT) ([]resu
 	}
 	val, ok := cMap[key]
 	if !ok {
-		return errorMessage, fmt.Errorf("valor [%s] nao consta da string 'from' no elemento 'convert'", key)
+		return errorMessage, newError(msgConvertValueNotFound, key)
 	}
 	return []resultsT{newResult(val)}, nil
 }
@@ -1012,15 +1095,12 @@ func utc(forceVal string, line *lineT, json jsonT, options optionsT) ([]resultsT
 
 // EvalCondition evaluates a boolean expression
 func evalCondition(expr string, line *lineT) (bool, error) {
-	functions := map[string]govaluate.ExpressionFunction{
-		"strlen": func(args ...interface{}) (interface{}, error) {
-			length := len(args[0].(string))
-			return fmt.Sprintf("%d", length), nil
-		},
-	}
-	expression, err := govaluate.NewEvaluableExpressionWithFunctions(expr, functions)
+	expression, err := newExpression(expr)
 	if err != nil {
-		return false, fmt.Errorf("expressao invalida (%v)", expr)
+		if errorCode(err) != "" {
+			return false, err
+		}
+		return false, newError(msgInvalidExpression, expr)
 	}
 	params := make(map[string]interface{})
 	for k, v := range line.fields {
@@ -1031,22 +1111,26 @@ func evalCondition(expr string, line *lineT) (bool, error) {
 		return false, errE
 	}
 	if result == nil {
-		return false, fmt.Errorf("expressao invalida (%v), parametros (%v)", expr, params)
+		return false, newError(msgInvalidExpressionArgs, expr, params)
+	}
+	cond, ok := result.(bool)
+	if !ok {
+		return false, newError(msgInvalidExpression, expr)
 	}
-	return result.(bool), nil
+	return cond, nil
 }
 
-// Seconds returns the total seconds from a time
+// Seconds returns a duration formatted as HH:MM:SS
 func seconds(forceVal string, line *lineT, json jsonT, options optionsT) ([]resultsT, error) {
 	field, errT := fieldTrunc(forceVal, line, json, options)
 	if errT != nil {
 		return errorMessage, errT
 	}
-	t, errP := time.Parse("03:04:05", field[0].val)
+	d, errP := parseDuration(field[0].val)
 	if errP != nil {
 		return errorMessage, errP
 	}
-	return []resultsT{newResult(formatHMS(t))}, nil
+	return []resultsT{newResult(d.hms())}, nil
 }
 
 // SurnameName inverts name and surname
@@ -1119,11 +1203,11 @@ func option(forceVal string, _ *lineT, json jsonT, options optionsT) ([]resultsT
 	}
 	optField, okF := json["field"].(string)
 	if !okF {
-		return errorMessage, fmt.Errorf("elemento [%s] inexistente na option [%v]", "field", json)
+		return errorMessage, newError(msgOptionElement, "field", json)
 	}
 	val, okO := options["options"][optField]
 	if !okO {
-		return errorMessage, fmt.Errorf("elemento [%s] inexistente nas options [%v], [%v]", optField, options, json)
+		return errorMessage, newError(msgOptionsElement, optField, options, json)
 	}
 	return []resultsT{newResult(val)}, nil
 }
@@ -1168,19 +1252,19 @@ func boxTechnology(forceVal string, line *lineT, json jsonT, options optionsT) (
 	case ".ism":
 		result = "HSS"
 	default:
-		return errorMessage, fmt.Errorf("tecnologia indeterminada para a extensao: [%s] na linha %d", filename, line.idx)
+		return errorMessage, newError(msgUnknownTechnology, filename, line.idx)
 	}
 	return []resultsT{newResult(result)}, nil
 }
 
 // Undefined returns a value to indicate an undefined function
 func undefined(value string, _ *lineT, _ jsonT, _ optionsT) ([]resultsT, error) {
-	return []resultsT{newResult("##UNDEFINED##")}, fmt.Errorf("funcao indefinida: [%s]", value)
+	return []resultsT{newResult("##UNDEFINED##")}, newError(msgFunctionUndefined, value)
 }
 
 // FirstName returns the first name of a composite name
-func firstName(forceVal string, _ *lineT, json jsonT, _ optionsT) ([]resultsT, error) {
-	names := getNames(forceVal, json)
+func firstName(forceVal string, _ *lineT, json jsonT, options optionsT) ([]resultsT, error) {
+	names := getNames(forceVal, json, options)
 	result := ""
 	if len(names) >= 1 {
 		result = names[0]
@@ -1189,8 +1273,8 @@ func firstName(forceVal string, _ *lineT, json jsonT, _ optionsT) ([]resultsT, e
 }
 
 // LastName returns the first name of a composite name
-func lastName(forceVal string, _ *lineT, json jsonT, _ optionsT) ([]resultsT, error) {
-	names := getNames(forceVal, json)
+func lastName(forceVal string, _ *lineT, json jsonT, options optionsT) ([]resultsT, error) {
+	names := getNames(forceVal, json, options)
 	result := ""
 	length := len(names)
 	if length > 1 {
@@ -1200,8 +1284,8 @@ func lastName(forceVal string, _ *lineT, json jsonT, _ optionsT) ([]resultsT, er
 }
 
 // MiddleName returns the first name of a composite name
-func middleName(forceVal string, _ *lineT, json jsonT, _ optionsT) ([]resultsT, error) {
-	names := getNames(forceVal, json)
+func middleName(forceVal string, _ *lineT, json jsonT, options optionsT) ([]resultsT, error) {
+	names := getNames(forceVal, json, options)
 	result := ""
 	length := len(names)
 	if length > 2 {
@@ -1211,7 +1295,7 @@ func middleName(forceVal string, _ *lineT, json jsonT, _ optionsT) ([]resultsT,
 }
 
 // getNames splits the names from a composite name
-func getNames(forceVal string, json jsonT) []string {
+func getNames(forceVal string, json jsonT, options optionsT) []string {
 	value := ""
 	if forceVal != "" {
 		value = forceVal
@@ -1233,7 +1317,7 @@ func getNames(forceVal string, json jsonT) []string {
 func getValue(key string, json jsonT) (string, error) {
 	value, ok := json[key].(string)
 	if !ok {
-		return "###", fmt.Errorf("chave [%v] nao encontrada no elemento json [%v]", key, json)
+		return "###", newError(msgJSONKeyNotFound, key, json)
 	}
 	return value, nil
 }
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	js "encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Box JSON output files
+const (
+	boxAssetsFile     = "assets.json"
+	boxCategoriesFile = "categories.json"
+	boxSeriesFile     = "series.json"
+)
+
+// Definitions shared by the Box schemas. Image types and id formats are the ones agreed with Box,
+// the other constraints come from the Tucano generic ingest v1.2 (docs/box/Tucano Json). Empty ids
+// in the lists of a category are ignored by the ingest (see the Alpha samples)
+const boxSchemaDefinitions = `
+	"definitions": {
+		"id": {"type": "string", "pattern": "^\\S+$"},
+		"language": {"type": "string", "pattern": "^[a-z]{3}$"},
+		"translations": {"type": "object"},
+		"image": {
+			"type": "object",
+			"required": ["id", "type", "location"],
+			"properties": {
+				"id": {"$ref": "#/definitions/id"},
+				"type": {"type": "string", "enum": ["vod-poster", "vod-background"]},
+				"language": {"$ref": "#/definitions/language"},
+				"location": {"type": "string", "pattern": "\\S"}
+			}
+		},
+		"images": {"type": "array", "items": {"$ref": "#/definitions/image"}},
+		"ids": {"type": "array", "items": {"type": "string", "pattern": "^\\S*$"}}
+	}`
+
+// boxAssetsSchema is the JSON schema of assets.json. The subtitles of the medias are delivered in
+// 'subtitles', so 'subtitles_languages' is optional
+const boxAssetsSchema = `{` + boxSchemaDefinitions + `,
+	"type": "object",
+	"required": ["assets"],
+	"properties": {
+		"assets": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"required": ["adult", "available_from", "available_to", "duration", "genres", "id", "images",
+					"medias", "metadata", "morality_level", "synopsis", "title"],
+				"properties": {
+					"id": {"$ref": "#/definitions/id"},
+					"title": {"$ref": "#/definitions/translations"},
+					"subtitle": {"$ref": "#/definitions/translations"},
+					"synopsis": {"$ref": "#/definitions/translations"},
+					"adult": {"type": "boolean"},
+					"available_from": {"type": "integer"},
+					"available_to": {"type": "integer"},
+					"duration": {"type": "integer"},
+					"morality_level": {"type": "integer"},
+					"series_id": {"type": "string"},
+					"season_id": {"type": "string"},
+					"season_episode_number": {"type": "integer"},
+					"genres": {"type": "array", "items": {"type": "string"}},
+					"metadata": {"type": "object"},
+					"images": {"$ref": "#/definitions/images"},
+					"medias": {
+						"type": "array",
+						"items": {
+							"type": "object",
+							"required": ["audio_languages", "id", "location", "metadata", "title", "type"],
+							"properties": {
+								"id": {"$ref": "#/definitions/id"},
+								"type": {"type": "string", "enum": ["MEDIA", "TRAILER"]},
+								"status": {"type": "string",
+									"enum": ["TO_BE_TRANSCODED", "TRANSCODED", "READY_FOR_ORIGIN", "READY_FOR_CDN", "READY"]},
+								"title": {"type": "string"},
+								"location": {"type": "string", "pattern": "\\S"},
+								"technology": {"type": "string"},
+								"drm": {"type": "string"},
+								"audio_languages": {"type": "array", "items": {"$ref": "#/definitions/language"}},
+								"subtitles_languages": {"type": "array", "items": {"$ref": "#/definitions/language"}},
+								"metadata": {"type": "object"}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}`
+
+// boxCategoriesSchema is the JSON schema of categories.json. The Box ingest also accepts the morality
+// level as a numeric string
+const boxCategoriesSchema = `{` + boxSchemaDefinitions + `,
+	"type": "object",
+	"required": ["categories"],
+	"properties": {
+		"categories": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"required": ["id", "name", "hidden", "morality_level", "parental_control", "adult", "metadata", "images", "parent_id"],
+				"properties": {
+					"id": {"$ref": "#/definitions/id"},
+					"name": {"$ref": "#/definitions/translations"},
+					"description_translations": {"$ref": "#/definitions/translations"},
+					"hidden": {"type": "boolean"},
+					"morality_level": {"type": ["integer", "string"], "pattern": "^[0-9]+$"},
+					"parental_control": {"type": "boolean"},
+					"adult": {"type": "boolean"},
+					"downloadable": {"type": ["boolean", "null"]},
+					"offline": {"type": ["boolean", "null"]},
+					"metadata": {"type": "object"},
+					"images": {"$ref": "#/definitions/images"},
+					"parent_id": {"type": ["string", "null"]},
+					"assets": {"$ref": "#/definitions/ids"},
+					"series": {"$ref": "#/definitions/ids"}
+				}
+			}
+		}
+	}
+}`
+
+// boxSeriesSchema is the JSON schema of series.json
+const boxSeriesSchema = `{` + boxSchemaDefinitions + `,
+	"type": "object",
+	"required": ["series"],
+	"properties": {
+		"series": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"required": ["id", "external_ids", "title", "synopsis", "images", "seasons"],
+				"properties": {
+					"id": {"$ref": "#/definitions/id"},
+					"external_ids": {"type": "object"},
+					"title": {"$ref": "#/definitions/translations"},
+					"synopsis": {"$ref": "#/definitions/translations"},
+					"morality_level": {"type": "integer"},
+					"adult": {"type": "boolean"},
+					"metadata": {"type": "object"},
+					"images": {"$ref": "#/definitions/images"},
+					"seasons": {
+						"type": "array",
+						"items": {
+							"type": "object",
+							"required": ["id", "title", "synopsis", "season_number", "images"],
+							"properties": {
+								"id": {"$ref": "#/definitions/id"},
+								"external_ids": {"type": "object"},
+								"title": {"$ref": "#/definitions/translations"},
+								"synopsis": {"$ref": "#/definitions/translations"},
+								"season_number": {"type": "integer"},
+								"metadata": {"type": "object"},
+								"images": {"$ref": "#/definitions/images"},
+								"assets": {"$ref": "#/definitions/ids"}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}`
+
+// Bundled JSON schemas, by output file
+var boxSchemas = map[string]map[string]interface{}{
+	boxAssetsFile:     mustParseSchema(boxAssetsSchema),
+	boxCategoriesFile: mustParseSchema(boxCategoriesSchema),
+	boxSeriesFile:     mustParseSchema(boxSeriesSchema),
+}
+
+// mustParseSchema parses a bundled JSON schema
+func mustParseSchema(src string) map[string]interface{} {
+	var schema map[string]interface{}
+	if err := js.Unmarshal([]byte(src), &schema); err != nil {
+		panic(newError(msgSchemaJSON, err))
+	}
+	return schema
+}
+
+// jsonValidatorT validates a document against a JSON schema. Only the keywords used by the bundled
+// schemas are supported: type, required, properties, items, enum, pattern and local $ref
+type jsonValidatorT struct {
+	root map[string]interface{}
+	file string
+	errs []error
+}
+
+// validateJSON validates a document against a schema
+func validateJSON(schema map[string]interface{}, file string, doc interface{}) []error {
+	val, err := normalizeJSON(doc)
+	if err != nil {
+		return []error{fmt.Errorf("%s: %w", file, err)}
+	}
+	v := &jsonValidatorT{root: schema, file: file}
+	v.check(schema, val, "")
+	return v.errs
+}
+
+// normalizeJSON converts a structure built by the writer to the generic types of encoding/json
+func normalizeJSON(doc interface{}) (interface{}, error) {
+	buf, err := js.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	dec := js.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	var val interface{}
+	err = dec.Decode(&val)
+	return val, err
+}
+
+// fail records an error of the catalog. The messages receive the file and the path before args
+func (v *jsonValidatorT) fail(path string, code string, args ...interface{}) {
+	if path == "" {
+		path = "/"
+	}
+	v.errs = append(v.errs, newError(code, append([]interface{}{v.file, path}, args...)...))
+}
+
+// check validates a value against a schema
+func (v *jsonValidatorT) check(schema map[string]interface{}, val interface{}, path string) {
+	if ref, ok := schema["$ref"].(string); ok {
+		if schema = v.resolve(ref); schema == nil {
+			v.fail(path, msgJSONSchemaRef, ref)
+			return
+		}
+	}
+	if types := schemaTypes(schema["type"]); len(types) > 0 {
+		if t := jsonType(val); !contains(types, t) && !(t == "integer" && contains(types, "number")) {
+			v.fail(path, msgJSONType, t, strings.Join(types, msg(msgOr)))
+			return
+		}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if fmt.Sprint(e) == fmt.Sprint(val) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(path, msgJSONEnum, val, enum)
+		}
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		if s, isStr := val.(string); isStr && !regexp.MustCompile(pattern).MatchString(s) {
+			v.fail(path, msgJSONPattern, s, pattern)
+		}
+	}
+	switch vv := val.(type) {
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, r := range required {
+				if _, has := vv[r.(string)]; !has {
+					v.fail(path, msgJSONRequired, r)
+				}
+			}
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(vv))
+		for k := range vv {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if ps, ok := props[k].(map[string]interface{}); ok {
+				v.check(ps, vv[k], path+"/"+k)
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range vv {
+				v.check(items, item, fmt.Sprintf("%s/%d", path, i))
+			}
+		}
+	}
+}
+
+// resolve returns the schema of a local reference ("#/definitions/name")
+func (v *jsonValidatorT) resolve(ref string) map[string]interface{} {
+	var node interface{} = v.root
+	for _, p := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		node = m[p]
+	}
+	schema, _ := node.(map[string]interface{})
+	return schema
+}
+
+// schemaTypes returns the types allowed by the 'type' keyword
+func schemaTypes(t interface{}) (types []string) {
+	switch tt := t.(type) {
+	case string:
+		types = []string{tt}
+	case []interface{}:
+		for _, s := range tt {
+			types = append(types, fmt.Sprint(s))
+		}
+	}
+	return
+}
+
+// jsonType returns the JSON schema type of a value
+func jsonType(val interface{}) string {
+	switch vv := val.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case js.Number:
+		if _, err := vv.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", val)
+}
+
+// validateBoxJSON validates the Box JSON files before they are saved: each file against its schema,
+// and the references of the categories to the assets, series and parent categories. Returns the
+// invalid files. Files not generated are nil
+func validateBoxJSON(assets interface{}, categs interface{}, series interface{}) (invalid []string, errs []error) {
+	docs := map[string]interface{}{boxAssetsFile: assets, boxCategoriesFile: categs, boxSeriesFile: series}
+	vals := make(map[string]interface{})
+	for _, file := range []string{boxAssetsFile, boxCategoriesFile, boxSeriesFile} {
+		if docs[file] == nil {
+			continue
+		}
+		fErrs := validateJSON(boxSchemas[file], file, docs[file])
+		if len(fErrs) > 0 {
+			invalid = append(invalid, file)
+			errs = append(errs, fErrs...)
+		}
+		vals[file], _ = normalizeJSON(docs[file])
+	}
+	if vals[boxCategoriesFile] == nil {
+		return
+	}
+	assetIDs := collectIDs(vals[boxAssetsFile], "assets")
+	seriesIDs := collectIDs(vals[boxSeriesFile], "series")
+	categIDs := collectIDs(vals[boxCategoriesFile], "categories")
+	var refErrs []error
+	categList, _ := vals[boxCategoriesFile].(map[string]interface{})["categories"].([]interface{})
+	for _, c := range categList {
+		categ, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id := fmt.Sprint(categ["id"])
+		// the categories of the series tree list their child categories in 'assets'
+		for _, a := range idList(categ["assets"]) {
+			if a != "" && !assetIDs[a] && !categIDs[a] {
+				refErrs = append(refErrs, newError(msgCategAssetRef,
+					boxCategoriesFile, id, a, boxAssetsFile))
+			}
+		}
+		for _, s := range idList(categ["series"]) {
+			if s != "" && !seriesIDs[s] {
+				refErrs = append(refErrs, newError(msgCategSerieRef,
+					boxCategoriesFile, id, s, boxSeriesFile))
+			}
+		}
+		if parent, _ := categ["parent_id"].(string); parent != "" && !categIDs[parent] {
+			refErrs = append(refErrs, newError(msgCategParentRef,
+				boxCategoriesFile, id, parent, boxCategoriesFile))
+		}
+	}
+	if len(refErrs) > 0 {
+		if !contains(invalid, boxCategoriesFile) {
+			invalid = append(invalid, boxCategoriesFile)
+		}
+		errs = append(errs, refErrs...)
+	}
+	return
+}
+
+// collectIDs returns the ids of the elements of a list of a document
+func collectIDs(doc interface{}, list string) map[string]bool {
+	ids := make(map[string]bool)
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return ids
+	}
+	elems, _ := m[list].([]interface{})
+	for _, e := range elems {
+		if el, okEl := e.(map[string]interface{}); okEl {
+			if id, okID := el["id"].(string); okID {
+				ids[id] = true
+			}
+		}
+	}
+	return ids
+}
+
+// idList returns the strings of a list of ids
+func idList(val interface{}) (ids []string) {
+	list, _ := val.([]interface{})
+	for _, v := range list {
+		if s, ok := v.(string); ok {
+			ids = append(ids, s)
+		}
+	}
+	return
+}
@@ -4,6 +4,7 @@ import (
 	js "encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"path"
 	"strconv"
 	"strings"
@@ -11,10 +12,9 @@ import (
 	"github.com/golang-collections/collections/stack"
 )
 
-var consolidated interface{} // TODO refactor global var
-
 // jsonWriter represents a writer to a JSON file
 type jsonWriter struct {
+	run        *runT
 	fileName   string
 	root       interface{}
 	st         stack.Stack
@@ -24,9 +24,9 @@ type jsonWriter struct {
 }
 
 // newJSONWriter creates a new struct
-func newJSONWriter(filename string, categLines []lineT, serieLines []lineT, jType int) (*jsonWriter, error) {
+func newJSONWriter(run *runT, filename string, categLines []lineT, serieLines []lineT, jType int) (*jsonWriter, error) {
 	var err error
-	w := jsonWriter{fileName: filename, categLines: categLines, serieLines: serieLines}
+	w := jsonWriter{run: run, fileName: filename, categLines: categLines, serieLines: serieLines}
 	switch jType {
 	case assetsT:
 		return &w, nil
@@ -115,7 +115,7 @@ func (wr *jsonWriter) insertElement(name string, elem interface{}, elType elemTy
 			wr.st.Push(c)
 			//fmt.Printf("** %#v\n", c)
 		case interface{}:
-			return fmt.Errorf("ERRO: InsertElement(interface{}): %#v\n", c)
+			return newError(msgInvalidInsert, c)
 		default:
 			//fmt.Printf("**** %#v\n", c)
 		}
@@ -127,8 +127,9 @@ func (wr *jsonWriter) Write(_ string) error {
 	return nil
 }
 
-// WriteAttr writes a subelement
-func (wr *jsonWriter) WriteAttr(name string, value string, vtype string, _ string) error {
+// WriteAttr writes a subelement. A value that can't be converted to vtype is written as
+// errorMessage and returned as a conversionErrorT
+func (wr *jsonWriter) WriteAttr(name string, value string, vtype string, _ string) (err2 error) {
 	current := wr.st.Peek()
 	if current == nil {
 		wr.root = value
@@ -141,36 +142,52 @@ func (wr *jsonWriter) WriteAttr(name string, value string, vtype string, _ strin
 			case "int":
 				val, err := strconv.Atoi(value)
 				if err != nil {
-					// fmt.Printf("%s *--------> %#v\n", name, val)
-					c[name] = errorMessage[0].val
+					c[name], err2 = errorMessage[0].val, newConversionError(name, value, vtype, err)
 					break
 				}
 				c[name] = val
 			case "float":
 				if value == "" {
-					log(fmt.Sprintf("ERRO field [%s]: [empty]", name))
-					c[name] = errorMessage[0].val
+					c[name], err2 = errorMessage[0].val, newConversionError(name, value, vtype, newError(msgEmptyValue))
 					break
 				}
 				fl, err := strconv.ParseFloat(value, 64)
 				if err != nil {
-					c[name] = errorMessage[0].val
-					log(fmt.Sprintf("ERRO field [%s]: [%v]", name, err))
+					c[name], err2 = errorMessage[0].val, newConversionError(name, value, vtype, err)
 					break
 				}
 				//val := strconv.FormatFloat(fl, 'f', 2, 32)
 				c[name] = fl
-			case "timestamp":
-				var val, err = toTimestamp(value)
+			case "money":
+				if value == "" {
+					c[name], err2 = errorMessage[0].val, newConversionError(name, value, vtype, newError(msgEmptyValue))
+					break
+				}
+				fl, err := parseMoney(value, moneyLocale(wr.run.options))
 				if err != nil {
-					// fmt.Printf("%s *--------> %#v\n", name, val)
-					c[name] = errorMessage[0].val
+					c[name], err2 = errorMessage[0].val, newConversionError(name, value, vtype, err)
 					break
 				}
-				c[name] = val
+				c[name] = math.Round(fl*100) / 100
+			case "timestamp", "time_s", "time_m", "time":
+				d, err := parseDuration(value)
+				if err != nil {
+					c[name], err2 = errorMessage[0].val, newConversionError(name, value, vtype, err)
+					break
+				}
+				switch vtype {
+				case "timestamp":
+					c[name] = d.millis()
+				case "time_s":
+					c[name] = d.seconds()
+				case "time_m":
+					c[name] = d.minutes()
+				case "time":
+					c[name] = d.hoursMinutes()
+				}
 			case "boolean":
 				if value != "true" && value != "false" {
-					c[name] = errorMessage[0].val
+					c[name], err2 = errorMessage[0].val, newConversionError(name, value, vtype, newError(msgInvalidBoolean))
 					break
 				}
 				c[name] = value == "true"
@@ -188,7 +205,7 @@ func (wr *jsonWriter) WriteAttr(name string, value string, vtype string, _ strin
 		}
 	}
 	//fmt.Printf("\"%s\": %s\n", name, value)
-	return nil
+	return
 }
 
 // EndElem closes a JSON element
@@ -215,13 +232,13 @@ func (wr *jsonWriter) OpenOutput() error {
 
 // WriteAndClose writes the structure to an external file
 func (wr *jsonWriter) WriteAndClose(_ string) error {
-	if consolidated == nil {
-		consolidated = wr.root
+	if wr.run.consolidated == nil {
+		wr.run.consolidated = wr.root
 	} else {
-		arrCons := consolidated.(map[string]interface{})["assets"].([]interface{})
+		arrCons := wr.run.consolidated.(map[string]interface{})["assets"].([]interface{})
 		arrNew := wr.root.(map[string]interface{})["assets"].([]interface{})[0]
-		consolidated.(map[string]interface{})["assets"] = append(arrCons, arrNew)
-		wr.root = consolidated
+		wr.run.consolidated.(map[string]interface{})["assets"] = append(arrCons, arrNew)
+		wr.root = wr.run.consolidated
 	}
 	//result, err := js.MarshalIndent(wr.root, "", "  ")
 	//if err != nil {
@@ -233,18 +250,19 @@ func (wr *jsonWriter) WriteAndClose(_ string) error {
 
 // WriteConsolidated writes additional files
 func (wr *jsonWriter) WriteConsolidated(mode int) (bufAssets []byte, bufCategs []byte, bufSeries []byte, err error) {
-	bufAssets, err = js.MarshalIndent(consolidated, "", "  ")
+	bufAssets, err = js.MarshalIndent(wr.run.consolidated, "", "  ")
 	if err != nil {
 		return
 	}
 	if !wr.testing {
-		fileAssets := path.Join(wr.fileName, "assets.json")
-		log("Salvando " + fileAssets)
+		fileAssets := path.Join(wr.fileName, boxAssetsFile)
+		log(msg(msgSaving, fileAssets))
 		err = ioutil.WriteFile(fileAssets, bufAssets, 0644)
 		if err != nil {
-			err = fmt.Errorf("ERRO ao criar arquivo [%#v]: %v", fileAssets, err)
+			err = newError(msgCreateFile, fileAssets, err)
 			return
 		}
+		wr.run.registerOutput(fileAssets)
 	}
 	switch mode {
 	case categsT:
@@ -256,13 +274,14 @@ func (wr *jsonWriter) WriteConsolidated(mode int) (bufAssets []byte, bufCategs [
 			return
 		}
 		if !wr.testing {
-			fileCateg := path.Join(wr.fileName, "categories.json")
-			log("Salvando " + fileCateg)
+			fileCateg := path.Join(wr.fileName, boxCategoriesFile)
+			log(msg(msgSaving, fileCateg))
 			err = ioutil.WriteFile(fileCateg, bufCategs, 0644)
 			if err != nil {
-				err = fmt.Errorf("ERRO ao criar arquivo [%#v]: %v", fileCateg, err)
+				err = newError(msgCreateFile, fileCateg, err)
 				return
 			}
+			wr.run.registerOutput(fileCateg)
 		}
 	case seriesT:
 		bufSeries, err = js.MarshalIndent(wr.root, "", "  ")
@@ -270,13 +289,14 @@ func (wr *jsonWriter) WriteConsolidated(mode int) (bufAssets []byte, bufCategs [
 			return
 		}
 		if !wr.testing {
-			fileSeries := path.Join(wr.fileName, "series.json")
-			log("Salvando " + fileSeries)
+			fileSeries := path.Join(wr.fileName, boxSeriesFile)
+			log(msg(msgSaving, fileSeries))
 			err = ioutil.WriteFile(fileSeries, bufSeries, 0644)
 			if err != nil {
-				err = fmt.Errorf("ERRO ao criar arquivo [%#v]: %v", fileSeries, err)
+				err = newError(msgCreateFile, fileSeries, err)
 				return
 			}
+			wr.run.registerOutput(fileSeries)
 		}
 	}
 	return
@@ -292,7 +312,7 @@ func (wr *jsonWriter) initCateg() (map[string]interface{}, error) {
 		if !ok || id == "" {
 			name, ok2 := line.fields["name"]
 			if ok2 {
-				fmt.Printf("WARNING: categoria [%s] nao existente na aba 'categories'", name)
+				fmt.Print(msg(msgCategNotInSheet, name))
 			}
 			continue
 		}
@@ -351,8 +371,8 @@ func (wr *jsonWriter) initSeries() (map[string]interface{}, error) {
 	root := make(map[string]interface{})
 	cat := make([]map[string]interface{}, 0)
 	//fmt.Printf("Series: [%#v]\n", wr.serieLines)
-	current := make(map[string]interface{})
-	currentId := ""
+	byID := make(map[string]map[string]interface{})
+	appended := make(map[string]bool)
 	seriesBack := ""
 	seriesPoster := ""
 	for _, line := range wr.serieLines {
@@ -362,21 +382,18 @@ func (wr *jsonWriter) initSeries() (map[string]interface{}, error) {
 			return nil, erri
 		}
 
-		toAppend := true
 		name, _ := line.fields["title"]
 		//fmt.Printf("serie:[%s]\n", name)
 		id, ok := line.fields["id"]
 		if !ok || id == "" {
-			return nil, fmt.Errorf("serie [%s] nao existente na aba 'series'", name)
+			return nil, newError(msgSeriesNotInSheet, name)
 		}
-		var el map[string]interface{}
-		if id != currentId {
+		el, found := byID[id]
+		if !found {
+			// the lines of a series may be anywhere in the sheet
 			el = make(map[string]interface{})
-			current = el
-			toAppend = currentId != ""
-			currentId = id
+			byID[id] = el
 		}
-		el = current
 		el["id"] = id
 
 		if numSeason < 0 {
@@ -405,7 +422,7 @@ func (wr *jsonWriter) initSeries() (map[string]interface{}, error) {
 		for _, l := range strNames {
 			vals := strings.Split(l, ":")
 			if len(vals) < 2 {
-				err := fmt.Errorf("erro ao ler serie, valor invalido [%s]", name)
+				err := newError(msgInvalidSeriesValue, name)
 				return nil, err
 			}
 			elName[strings.TrimSpace(vals[0])] = strings.TrimSpace(vals[1])
@@ -421,7 +438,10 @@ func (wr *jsonWriter) initSeries() (map[string]interface{}, error) {
 		if err != nil {
 			return nil, err
 		}
-		el["images"] = make([]interface{}, 0)
+		if _, ok := el["images"]; !ok {
+			// kept if given by the line of season -1
+			el["images"] = make([]interface{}, 0)
+		}
 		elSeas, okS := el["seasons"].([]interface{})
 		if !okS {
 			elSeas = make([]interface{}, 0)
@@ -461,10 +481,9 @@ func (wr *jsonWriter) initSeries() (map[string]interface{}, error) {
 		elSeas = append(elSeas, elSeasM)
 		el["seasons"] = elSeas
 
-		if toAppend {
-			cat = append(cat, el)
-		} else {
+		if !appended[id] {
 			cat = append(cat, el)
+			appended[id] = true
 		}
 	}
 
@@ -481,7 +500,7 @@ func splitLangName(str string) (map[string]string, error) {
 		langSplit := strings.Split(langEl, ":")
 		numEls := len(langSplit)
 		if numEls != 2 {
-			return nil, fmt.Errorf("campo de linguagem tem %d elementos, deveria ter 2: [%s]", numEls, langEl)
+			return nil, newError(msgLanguageField, numEls, langEl)
 		}
 		lang := strings.TrimSpace(langSplit[0])
 		text := strings.TrimSpace(langSplit[1])
@@ -497,7 +516,7 @@ func (wr *jsonWriter) addToCategories(id string, idCateg string, categName strin
 	}
 	r := wr.root.(map[string]interface{})
 	categs := r[rootEl].([]map[string]interface{})
-	log(fmt.Sprintf("criando nova categoria: [%s], id: [%s], parent:[%s]", categName, idCateg, idParent))
+	log(msg(msgNewCateg, categName, idCateg, idParent))
 	if seriesId != "" {
 		// search custom categories
 		for _, categ := range categs {
@@ -511,7 +530,7 @@ func (wr *jsonWriter) addToCategories(id string, idCateg string, categName strin
 						return nil
 					}
 				}
-				log(fmt.Sprintf("adicionando: [%s], id: [%s]", categName, id))
+				log(msg(msgAddingCateg, categName, id))
 				categ[element] = append(categ[element].([]interface{}), id)
 				return nil
 			}
@@ -659,7 +678,7 @@ func findSerie(series []lineT, studio string, name string, season string) (*line
 		}
 		return &line, nil
 	}
-	return nil, fmt.Errorf("serie com nome [%s] e temporada [%s] nao encontrada. Adicionar na aba 'series'", name, season)
+	return nil, newError(msgSeriesSeasonNotFound, name, season)
 }
 
 func findCateg(categs []lineT, name string) (*lineT, error) {
@@ -673,7 +692,7 @@ func findCateg(categs []lineT, name string) (*lineT, error) {
 		}
 		return &line, nil
 	}
-	return nil, fmt.Errorf("categoria com nome [%s] nao encontrada. Adicionar na aba 'categs'", name)
+	return nil, newError(msgCategNotFound, name)
 }
 
 // IMPORTANT: JsonWriter must be created separately for the series file - do not use this method for the assets file
@@ -698,46 +717,46 @@ func populateSerieIds(lines []lineT, options optionsT) error {
 	options["series"] = make(map[string]string)
 	idF, okO := options["options"]["series_id_field"]
 	if !okO {
-		return fmt.Errorf("opcao 'season_id_field' nao encontrada")
+		return newError(msgSeasonIdOption)
 	}
 	titleF, okT := options["options"]["series_title_field"]
 	if !okT {
-		return fmt.Errorf("opcao 'series_title_field' nao encontrada")
+		return newError(msgSeriesTitleOption)
 	}
 	nSeasonF, okN := options["options"]["season_num_field"]
 	if !okN {
-		return fmt.Errorf("opcao 'season_num_field' nao encontrada")
+		return newError(msgSeasonNumOption)
 	}
 	idSeasonF, okI := options["options"]["season_id_field"]
 	if !okI {
-		return fmt.Errorf("opcao 'season_id_field' nao encontrada")
+		return newError(msgSeasonIdOption)
 	}
 	options["series"] = make(map[string]string)
 	for i, line := range lines {
 		id, ok1 := line.fields[idF]
 		if !ok1 {
-			return fmt.Errorf("campo '%s' nao encontrado na planilha de series", idF)
+			return newError(msgSeriesColumn, idF)
 		}
 		title, ok2 := line.fields[titleF]
 		if !ok2 {
-			return fmt.Errorf("campo '%s' nao encontrado na planilha de series", titleF)
+			return newError(msgSeriesColumn, titleF)
 		}
 		sNames, err := splitLangName(title)
 		if err != nil {
 			// Sums 2 to line because array begins with 0 and there is a header column
-			return fmt.Errorf("erro na linha %d, coluna [%s]: %s", i+2, titleF, err.Error())
+			return newError(msgSeriesSheetError, i+2, titleF, err.Error())
 		}
 		titlePor, ok := sNames["por"]
 		if !ok {
-			return fmt.Errorf("serie '%s' nao tem nome em portugues", titleF)
+			return newError(msgSeriesNoPtName, titleF)
 		}
 		nSeason, ok3 := line.fields[nSeasonF]
 		if !ok3 {
-			return fmt.Errorf("campo '%s' nao encontrado na planilha de series", nSeason)
+			return newError(msgSeriesColumn, nSeason)
 		}
 		idSeason, ok4 := line.fields[idSeasonF]
 		if !ok4 {
-			return fmt.Errorf("campo '%s' nao encontrado na planilha de series", idSeasonF)
+			return newError(msgSeriesColumn, idSeasonF)
 		}
 		idSerie := fmt.Sprintf("%s|%s", titlePor, nSeason)
 		valSerie := fmt.Sprintf("%s|%s", id, idSeason)
@@ -9,6 +9,7 @@ import (
 	"path"
 	"regexp"
 	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -39,7 +40,14 @@ const dateformat = "01-02-06"
 
 type lineT struct {
 	fields map[string]string
-	idx    int
+	idx    int         // row number in the spreadsheet, as shown by Excel
+	sheet  *sheetInfoT // sheet the line was read from, nil if not read from a spreadsheet
+}
+
+// sheetInfoT describes a sheet read by readSheet
+type sheetInfoT struct {
+	name   string
+	header []string
 }
 
 func newLineT(idx int) lineT {
@@ -70,15 +78,13 @@ type writer interface {
 	Testing() bool
 }
 
-var options map[string]map[string]string
-var rs *reportSheet
-var xlsFilePath string
-
 const errSuffix = "_ERRO"
 
 func main() {
 	success := 0
 	var err error
+	summary := newRunSummary()
+	var run *runT
 
 	defer func() {
 		if msg := recover(); msg != nil {
@@ -94,70 +100,92 @@ func main() {
 			success = -3
 			logError(err)
 		}
+		if summary.ready {
+			summary.finish(run, success, err)
+			if errS := summary.write(); errS != nil {
+				logError(errS)
+			}
+		}
 		if success == 0 {
 			log("")
 			log("--------------------------------------")
-			log(" Processamento terminado com sucesso. ")
+			log(msg(msgSuccess))
 			log("--------------------------------------")
 		} else {
 			log("*******************************************")
-			log("*    ATENCAO: ERROS NO PROCESSAMENTO      *")
-			log("*       VERIFIQUE MENSAGENS ACIMA         *")
+			log(msg(msgFailureBanner1))
+			log(msg(msgFailureBanner2))
 			log("*******************************************")
 		}
+		os.Exit(exitCode(success))
 	}()
 
-	log("-------------------------")
-	log(" Iniciando processamento ")
-	log("-------------------------")
-	// defer func() { os.Exit(success) }()
 	inputXls := ""
 	confFile := ""
 	outType := ""
 	outDir := ""
 	inputXlsCat := ""
 	forceGenreCat := false
-	flag.StringVar(&inputXls, "xls", "", "Arquivo XLS de entrada")
-	flag.StringVar(&confFile, "config", "", "Arquivo JSON de configuracao")
-	flag.StringVar(&outType, "outtype", "xml", "Tipo de output (xml ou json). Default: xml")
-	flag.StringVar(&outDir, "outdir", "", "Diretorio de saida")
-	flag.StringVar(&inputXlsCat, "xlscat", "", "Arquivo Xls de categorias")
-	flag.BoolVar(&forceGenreCat, "genrecat", false, "So insere categorias que sao generos")
+	validateOnly := false
+	strict := false
+	mediaRoot := ""
+	msgLang := langPT
+	flag.StringVar(&inputXls, "xls", "", msg(msgFlagXls))
+	flag.StringVar(&confFile, "config", "", msg(msgFlagConfig))
+	flag.StringVar(&outType, "outtype", "xml", msg(msgFlagOutType))
+	flag.StringVar(&outDir, "outdir", "", msg(msgFlagOutDir))
+	flag.StringVar(&inputXlsCat, "xlscat", "", msg(msgFlagXlsCat))
+	flag.BoolVar(&forceGenreCat, "genrecat", false, msg(msgFlagGenreCat))
+	flag.BoolVar(&validateOnly, "validate", false, msg(msgFlagValidate))
+	flag.BoolVar(&strict, "strict", false, msg(msgFlagStrict))
+	flag.StringVar(&msgLang, "lang", langPT, msg(msgFlagLang))
+	flag.StringVar(&mediaRoot, "mediaroot", "", msg(msgFlagMediaRoot))
+	flag.Usage = usage
 	flag.Parse()
+	if errL := setLang(msgLang); errL != nil {
+		success = exitWithError(errL, 1)
+		return
+	}
+
+	log("-------------------------")
+	log(msg(msgStart))
+	log("-------------------------")
 
 	// test command line parameters
 	if inputXls == "" {
-		success = exitWithError("arquivo XLS deve ser especificado na linha de comando", 1)
+		success = exitWithError(newError(msgXlsRequired), 1)
 		return
 	}
 	if confFile == "" {
-		success = exitWithError("arquivo JSON de configuracao deve ser especificado na linha de comando", 1)
+		success = exitWithError(newError(msgConfigRequired), 1)
 		return
 	}
-	if outType != "xml" && outType != "json" {
-		success = exitWithError(fmt.Sprintf("tipo de arquivo de saida invalido: outType = [%s]", outType), 1)
+	if !contains([]string{"xml", "adi3", "mrss", "json", "epg"}, outType) {
+		success = exitWithError(newError(msgInvalidOutType, outType), 1)
 		return
 	}
 	if outDir != "" {
 		st, errS := os.Stat(outDir)
 		if errS != nil || !st.IsDir() {
-			logError(fmt.Errorf("diretorio [%s] nao e' valido", outDir))
-			success = 1
+			err = newError(msgInvalidOutDir, outDir)
 			return
 		}
 	}
 	if outType == "json" {
 		if inputXlsCat == "" {
-			success = exitWithError("arquivo XLS de categorias deve ser especificado na linha de comando", 1)
+			success = exitWithError(newError(msgXlsCatRequired), 1)
 			return
 		}
 
 	}
-	log(fmt.Sprintf("Planilha de entrada: [%s]", inputXls))
-	log(fmt.Sprintf("Arquivo config: [%s]", confFile))
-	log(fmt.Sprintf("Diretorio de saida: [%s]", outDir))
+	summary.Xls, summary.XlsCat, summary.Config = inputXls, inputXlsCat, confFile
+	summary.OutType, summary.OutDir, summary.Strict, summary.ValidateOnly = outType, outDir, strict, validateOnly
+	summary.ready = true
+	log(msg(msgInputSheet, inputXls))
+	log(msg(msgConfigFile, confFile))
+	log(msg(msgOutputDir, outDir))
 	if outType == "json" {
-		log(fmt.Sprintf("Planilha de categorias: [%s]", inputXlsCat))
+		log(msg(msgCategSheet, inputXlsCat))
 	}
 	log("-------------------------")
 
@@ -169,11 +197,16 @@ func main() {
 		return
 	}
 	defer closeSheet(spreadSheet)
-	lines, err = readSheetByName(spreadSheet, "dados")
+	sheetName := "dados"
+	if outType == "epg" {
+		sheetName = epgSheet
+	}
+	lines, err = readSheetByName(spreadSheet, sheetName)
 	if err != nil {
 		success = 1
 		return
 	}
+	summary.Rows = len(lines)
 	var linesCat []lineT
 	if outType == "json" {
 		var sheetCat *xlsx.Spreadsheet
@@ -194,9 +227,94 @@ func main() {
 		return
 	}
 	// init option vars
-	initVars(json)
+	run = newRun(json)
+	run.strict = strict
+	if mediaRoot != "" {
+		run.options["options"][mediaRootOpt] = mediaRoot
+	}
+	// pre-flight check: columns referenced by the config x sheet headers. Missing columns stop only the validate mode
+	if err = logColumnCheck(sheetName, checkColumns(json, run.options, sheetHeader(lines)), validateOnly); err != nil || validateOnly {
+		return
+	}
+	// in strict mode, files are written in a staging directory, moved to outDir only if there are no errors
+	runDir := outDir
+	if strict {
+		stagingParent := outDir
+		if stagingParent == "" {
+			stagingParent = "."
+		}
+		if runDir, err = ioutil.TempDir(stagingParent, ".xls2xml_"); err != nil {
+			return
+		}
+		defer os.RemoveAll(runDir)
+	}
 	var errs []error
-	success, errs = processSpreadSheet(json, outType, spreadSheet, outDir, lines, linesCat, forceGenreCat)
+	if outType == "epg" {
+		success, errs = processEPG(run, json, runDir, lines)
+	} else {
+		success, errs = processSpreadSheet(run, json, outType, spreadSheet, runDir, outDir, lines, linesCat, forceGenreCat)
+	}
+	run.reportErrors(errs...)
+	// no sentinel value can reach a delivered file
+	if errsC := run.checkOutputs(strict); len(errsC) > 0 {
+		for _, e := range errsC {
+			logError(e)
+		}
+		run.reportErrors(errsC...)
+		if success == 0 {
+			success = -1
+		}
+	}
+	if strict {
+		if run.errorCount() > 0 || success != 0 {
+			log(msg(msgStrictCancelled))
+			run.discardRowFiles()
+			if success == 0 {
+				success = -1
+			}
+		} else if err = run.commitOutputs(runDir, outDir); err != nil {
+			return
+		} else if run.xlsFilePath != "" {
+			run.xlsFilePath = path.Join(outDir, path.Base(run.xlsFilePath))
+		}
+	}
+	if run.packages != nil && !(strict && success != 0) {
+		// delivery packages of the assets
+		if errsP := run.packages.build(outDir); len(errsP) > 0 {
+			for _, e := range errsP {
+				logError(e)
+			}
+			run.reportErrors(errsP...)
+			if success == 0 {
+				success = -1
+			}
+		}
+	}
+	if run.uniqChecker != nil && !(strict && success != 0) {
+		// index of delivered values, used to check the next batches
+		if errI := run.uniqChecker.saveIndex(func(row int) bool { return run.rowStatus[row].ok }); errI != nil {
+			logError(errI)
+		}
+	}
+	if run.versions != nil && !(strict && success != 0) {
+		// versions of the delivered assets, used by the updates of the next batches
+		if errV := run.versions.save(func(row int) bool { return run.rowStatus[row].ok }); errV != nil {
+			logError(errV)
+		}
+	}
+	if errC := run.saveChecksumCache(); errC != nil {
+		logError(errC)
+	}
+	if errR := run.writeErrorReport(outDir); errR != nil {
+		logError(errR)
+	}
+	if len(lines) > 0 && lines[0].sheet != nil {
+		if annotated, errA := run.writeAnnotatedSheet(inputXls, lines[0].sheet, outDir); errA != nil {
+			logError(errA)
+		} else {
+			log(msg(msgAnnotatedWritten, annotated))
+		}
+	}
 	if len(errs) > 0 {
 		for _, e := range errs {
 			logError(e)
@@ -204,27 +322,44 @@ func main() {
 		return
 	}
 	if success == 0 {
-		log(fmt.Sprintf("Gravado arquivo de report: %s", xlsFilePath))
+		log(msg(msgReportWritten, run.xlsFilePath))
 	}
 }
 
-func exitWithError(errMessage string, errCode int) int {
-	logError(fmt.Errorf(errMessage))
+// usage prints the command line flags and the exit codes
+func usage() {
+	_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+	flag.PrintDefaults()
+	_, _ = fmt.Fprintln(flag.CommandLine.Output(), msg(msgUsageExitCodes))
+}
+
+func exitWithError(err error, errCode int) int {
+	logError(err)
 	flag.Usage()
 	return errCode
 }
 
-func processSpreadSheet(json map[string]interface{}, outType string, f *xlsx.Spreadsheet, outDir string, lines []lineT, linesCat []lineT, forceGenreCats bool) (success int, errs []error) {
-	filenameField, okf := options["options"]["filename_field"]
+// processSpreadSheet writes the files of the packs in outDir. deliveryDir is the directory where the files
+// will be delivered, different from outDir in strict mode. Like main, it returns 2 for options missing in the
+// config and -3 for sheets that can't be read, which abort the processing
+func processSpreadSheet(run *runT, json map[string]interface{}, outType string, f *xlsx.Spreadsheet, outDir string, deliveryDir string, lines []lineT, linesCat []lineT, forceGenreCats bool) (success int, errs []error) {
+	filenameField, okf := run.options["options"]["filename_field"]
 	if !okf || filenameField == "" {
-		return 2, []error{fmt.Errorf("ERRO ao procurar filename_field nas options [%#v]", options)}
+		return 2, []error{newError(msgFilenameFieldOption, run.options)}
 	}
-	nameField, okN := options["options"]["name_field"]
+	nameField, okN := run.options["options"]["name_field"]
 	if !okN || nameField == "" {
-		return 2, []error{fmt.Errorf("ERRO ao procurar name_field nas options [%#v]", options)}
+		return 2, []error{newError(msgNameFieldOption, run.options)}
+	}
+	if _, err := outputEncoding(run.options); err != nil {
+		return 2, []error{err}
+	}
+	var err error
+	if run.packages, err = newPackager(run, outType); err != nil {
+		return 2, []error{err}
 	}
 	nameField = strings.ToLower(nameField)
-	// fmt.Printf("**> [%v]: %#v\n", filenameField, options)
+	// fmt.Printf("**> [%v]: %#v\n", filenameField, run.options)
 	// fmt.Printf("***> [%v]: %#v\n", filename, line)
 
 	// fmt.Printf("--> %v\n", objmap)
@@ -233,41 +368,79 @@ func processSpreadSheet(json map[string]interface{}, outType string, f *xlsx.Spr
 	filePath := ""
 	var curr lineT
 	name := ""
-	idField, _ := options["options"]["id_field"]
+	idField, _ := run.options["options"]["id_field"]
 	// TODO Check categ fields
-	cField1 := strings.ToLower(options["options"]["categ_field1"])
-	cField2 := strings.ToLower(options["options"]["categ_field2"])
-	cField3 := strings.ToLower(options["options"]["categ_field3"])
+	cField1 := strings.ToLower(run.options["options"]["categ_field1"])
+	cField2 := strings.ToLower(run.options["options"]["categ_field2"])
+	cField3 := strings.ToLower(run.options["options"]["categ_field3"])
 	categFields := []string{cField1, cField2, cField3}
 	var wrCategs *jsonWriter
 	var wrSeries *jsonWriter
 	var categLines []lineT
 	var serieLines []lineT
-	var err error
 	if outType == "json" {
 		// Read categories sheet for Box format
 		categLines = linesCat
-		if serieLines, err = readSheetByName(f, "series"); err != nil {
-			return -1, []error{err}
+		switch mode := run.options["options"][seriesModeOpt]; mode {
+		case "", "sheet":
+			if serieLines, err = readSheetByName(f, "series"); err != nil {
+				return -3, []error{err}
+			}
+			if err = populateSerieIds(serieLines, run.options); err != nil {
+				return -1, []error{err}
+			}
+		case "derive":
+			// the 'series' sheet is optional, with the synopses and images
+			overrides, errS := readSheetByName(f, "series")
+			if errS != nil && errorCode(errS) != msgSheetNotFound {
+				return -3, []error{errS}
+			}
+			if serieLines, err = deriveSeries(lines, overrides, run.options); err != nil {
+				return -1, []error{err}
+			}
+		default:
+			return 2, []error{newError(msgOptionValue, seriesModeOpt, mode)}
 		}
-		if err = populateSerieIds(serieLines, options); err != nil {
+		// TODO usar createwriter
+		if wrCategs, err = newJSONWriter(run, outDir, categLines, serieLines, categsT); err != nil {
 			return -1, []error{err}
 		}
-		// TODO usar createwriter
-		if wrCategs, err = newJSONWriter(outDir, categLines, serieLines, categsT); err != nil {
+		if wrSeries, err = newJSONWriter(run, outDir, nil, serieLines, seriesT); err != nil {
 			return -1, []error{err}
 		}
-		if wrSeries, err = newJSONWriter(outDir, nil, serieLines, seriesT); err != nil {
+		if run.merger, err = newBoxMerger(run.options); err != nil {
 			return -1, []error{err}
 		}
+		if run.merger != nil {
+			run.merger.markDeleted(lines, strings.ToLower(idField), strings.ToLower(run.options["options"][verbFieldOpt]))
+		}
+	}
+	if run.uniqChecker, err = newUniqueChecker(run.options); err != nil {
+		return -1, []error{err}
+	}
+	if run.versions, err = newVersionRegistry(run.options); err != nil {
+		return -1, []error{err}
+	}
+	namer, err := newFileNamer(run.options, deliveryDir)
+	if err != nil {
+		return 2, []error{err}
+	}
+	rules, err := readRules(json)
+	if err != nil {
+		return -1, []error{err}
+	}
+	categTrees, err := readCategTrees(json)
+	if err != nil {
+		return -1, []error{err}
 	}
 	nLines := len(lines)
 	log("------------------------------")
-	log("Iniciando geracao de arquivos:")
+	log(msg(msgGenerating))
 	log("------------------------------")
 	var wr writer
+	pubFailed := false // errors in the publisher report, which keeps its rows
 	for i := 0; i < nLines; {
-		log(fmt.Sprintf("Processando linha %d...", i+1))
+		log(msg(msgProcessingLine, i+1))
 		var pack []lineT
 		// Groups lines with the same filename or empty filename
 		j := i
@@ -284,46 +457,119 @@ func processSpreadSheet(json map[string]interface{}, outType string, f *xlsx.Spr
 		}
 		i = j
 		// fmt.Printf("== %v\n", pack)
-		if filePath, err = replaceAllNonAlpha(path.Base(lName)); err != nil {
+		if filePath, err = namer.name(pack, lName); err != nil {
 			return -1, []error{err}
 		}
-		if filePath = strings.TrimSuffix(filePath, path.Ext(filePath)); filePath == "" {
-			logError(fmt.Errorf("ERRO ao procurar filename na linha [%#v], field [%v]", curr, filenameField))
+		if filePath == "" {
+			logError(newError(msgFilenameNotFound, curr, filenameField))
 			log("#ERRO FILENAME#")
 			continue
 		}
+		if filePath, err = namer.resolve(filePath, &pack[0]); err != nil {
+			logError(err)
+			run.reportErrors(err)
+			success = -1
+			run.setRowStatus(pack, "", false)
+			lName = name
+			continue
+		}
 		filePath = path.Join(outDir, filePath)
-		log(fmt.Sprintf("Arquivo: %s", filePath))
-		if wr, err = createWriter(outType, filePath, "", 0, 0,
+		log(msg(msgFile, filePath))
+		run.packCount++
+		if wr, err = createWriter(run, outType, filePath, "", 0, 0,
 			categLines, serieLines, assetsT); err != nil {
 			return -1, []error{err}
 		}
-		log("Escrevendo " + filePath)
-		if errs = processAssets(json, pack, wr); len(errs) > 0 {
+		if uErrs := run.uniqChecker.checkPack(pack, filePath+wr.Suffix()); len(uErrs) > 0 {
+			// Do not overwrite the file of another pack
+			for _, e := range uErrs {
+				logError(e)
+			}
+			run.reportErrors(uErrs...)
+			success = -1
+			run.setRowStatus(pack, "", false)
+			lName = name
+			continue
+		}
+		if ruleErrs := checkRules(rules, pack); len(ruleErrs) > 0 {
+			run.reportErrors(ruleErrs...)
+			if logRuleErrors(ruleErrs) {
+				// Do not write a pack violating the rules
+				success = -1
+				run.setRowStatus(pack, "", false)
+				lName = name
+				continue
+			}
+		}
+		if wr, err = run.versions.wrap(wr, pack, filePath+wr.Suffix()); err != nil {
+			logError(err)
+			run.reportErrors(err)
+			success = -1
+			run.setRowStatus(pack, "", false)
+			lName = name
+			continue
+		}
+		if unchanged(wr) {
+			// already delivered: no file
+			run.setRowStatus(pack, "", true)
+			lName = name
+			continue
+		}
+		log(msg(msgWriting, filePath))
+		if run.packages != nil {
+			run.packages.startPack()
+		}
+		packErrs := processAssets(run, json, pack, wr)
+		if len(packErrs) > 0 {
 			// Do not stop: log error and continue to other files
-			for _, e := range errs {
+			for _, e := range packErrs {
 				logError(e)
 			}
+			run.reportErrors(packErrs...)
 			success = -1
+			run.setRowStatus(pack, path.Base(wr.Filename()+errSuffix+wr.Suffix()), false)
+		} else {
+			run.setRowStatus(pack, path.Base(wr.Filename()+wr.Suffix()), true)
+			if run.packages != nil {
+				run.packages.addPack(path.Base(filePath), path.Base(wr.Filename()+wr.Suffix()))
+			}
 		}
 		lName = name
 		// publisher output
 		if jsonXls, hasPubOutput := json["xls_output"]; hasPubOutput && success == 0 {
 			JsonXlsMap := jsonXls.(map[string]interface{})
 			var suc int
-			if xlsFilePath, suc, errs = processPublisherXLS(JsonXlsMap, outDir, nLines, pack); len(errs) > 0 {
-				return -1, errs
+			if run.xlsFilePath, suc, errs = processPublisherXLS(run, JsonXlsMap, outDir, nLines, pack); len(errs) > 0 {
+				if run.strict {
+					return -1, errs
+				}
+				// Do not stop: the errors are recorded against the rows
+				for _, e := range errs {
+					logError(e)
+				}
+				run.reportErrors(errs...)
+				errs = nil
+				pubFailed = true
 			} else if suc != 0 {
 				success = suc
 			}
-			if rs != nil {
-				if err = rs.WriteAndClose(""); err != nil {
+			if run.rs != nil {
+				if err = run.rs.WriteAndClose(""); err != nil {
 					return -1, []error{err}
 				}
 			}
 		}
 		log("------------------------------------")
 	}
+	if pubFailed && success == 0 {
+		success = -1
+	}
+	if mw, isMRSS := unwrapWriter(wr).(*mrssWriter); isMRSS {
+		// one feed for the batch
+		if _, _, _, err = mw.WriteConsolidated(assetsT); err != nil {
+			return -1, []error{err}
+		}
+	}
 	if wr != nil && wrCategs != nil {
 		dirCategs := path.Dir(wrCategs.Filename())
 		dirSeries := path.Dir(wrSeries.Filename())
@@ -347,34 +593,53 @@ func processSpreadSheet(json map[string]interface{}, outType string, f *xlsx.Spr
 
 	if success == 0 {
 		// Main file successfully processed, process other outputs
-		if rs != nil {
+		if run.rs != nil {
 			// publisher report
-			if _, _, _, err = rs.WriteConsolidated(assetsT); err != nil {
+			if _, _, _, err = run.rs.WriteConsolidated(assetsT); err != nil {
 				return -1, []error{err}
 			}
 		}
 		if wrCategs != nil || wrSeries != nil {
 			// extra files
-			suc, errors := processSeries(lines, wrSeries, "id")
+			suc, errors := processSeries(run, lines, wrSeries, "id")
 			if len(errors) > 0 {
 				return -1, errors
 			} else if suc != 0 {
 				success = suc
 			}
-			catSeason, ok := options["options"]["categ_season"]
-			if !ok {
-				return -1, []error{fmt.Errorf("categ_season nao encontrada em options no config")}
-			}
-			categSeason, errc := strconv.Atoi(catSeason)
-			if errc != nil {
-				return -1, []error{err}
+			if len(categTrees) > 0 {
+				suc, errors = processCategTrees(lines, wrCategs, categTrees, idField, forceGenreCats)
+			} else {
+				catSeason, ok := run.options["options"]["categ_season"]
+				if !ok {
+					return 2, []error{newError(msgCategSeasonOption)}
+				}
+				categSeason, errc := strconv.Atoi(catSeason)
+				if errc != nil {
+					return 2, []error{newError(msgOptionValue, "categ_season", catSeason)}
+				}
+				suc, errors = processCategs(run, lines, wrCategs, wrSeries, idField, categFields, categSeason, forceGenreCats)
 			}
-			suc, errors = processCategs(lines, wrCategs, wrSeries, idField, categFields, categSeason, forceGenreCats)
 			if len(errors) > 0 {
 				return -1, errors
 			} else if suc != 0 {
 				success = suc
 			}
+			// validate before saving
+			var categsRoot interface{}
+			if wrCategs.categLines != nil {
+				categsRoot = wrCategs.root
+			}
+			if run.merger != nil {
+				// full catalog: the previous one with the changes of the run
+				if run.consolidated, categsRoot, wrSeries.root, err = run.merger.merge(run.consolidated, categsRoot, wrSeries.root); err != nil {
+					return -1, []error{err}
+				}
+				if categsRoot != nil {
+					wrCategs.root = categsRoot
+				}
+			}
+			invalid, vErrs := validateBoxJSON(run.consolidated, categsRoot, wrSeries.root)
 			// categories.json
 			if _, _, _, err = wrCategs.WriteConsolidated(categsT); err != nil {
 				return -1, []error{err}
@@ -383,46 +648,60 @@ func processSpreadSheet(json map[string]interface{}, outType string, f *xlsx.Spr
 			if _, _, _, err = wrSeries.WriteConsolidated(seriesT); err != nil {
 				return -1, []error{err}
 			}
+			if len(vErrs) > 0 {
+				for _, e := range vErrs {
+					logError(e)
+				}
+				run.reportErrors(vErrs...)
+				success = -1
+				for _, f := range invalid {
+					filename := path.Join(outDir, f)
+					if err = run.renameOutput(filename, errorFilename(filename)); err != nil {
+						return -1, []error{err}
+					}
+				}
+			}
 		}
 	}
 	return
 }
 
-func processPublisherXLS(JsonXlsMap map[string]interface{}, outDir string, nLines int, pack []lineT) (string, int, []error) {
+func processPublisherXLS(run *runT, JsonXlsMap map[string]interface{}, outDir string, nLines int, pack []lineT) (string, int, []error) {
 	var err error
 	success := 0
 	xlsFile, ok := JsonXlsMap["filename"].(string)
 	if !ok {
-		return "", -1, []error{fmt.Errorf("elemento 'filename' nao existe em xls_output no arquivo json")}
+		return "", -1, []error{newError(msgXlsOutputFilename)}
 	}
 	jsonCols, okCols := JsonXlsMap["columns"].([]interface{})
 	if !okCols {
-		return "", -1, []error{fmt.Errorf("elemento 'columns' nao existe em xls_output no arquivo json")}
+		return "", -1, []error{newError(msgXlsOutputColumns)}
 	}
 	sheetName, okS := JsonXlsMap["sheet"].(string)
 	if !okS {
-		return "", -1, []error{fmt.Errorf("elemento 'sheet' nao existe em xls_output no arquivo json")}
+		return "", -1, []error{newError(msgXlsOutputSheet)}
 	}
 	xlsFilepath := path.Join(outDir, xlsFile)
-	if rs == nil {
+	if run.rs == nil {
 		nCols := len(jsonCols)
-		if rs, err = newReportSheet(xlsFilepath, sheetName, nCols, nLines); err != nil {
+		if run.rs, err = newReportSheet(run, xlsFilepath, sheetName, nCols, nLines); err != nil {
 			return xlsFilepath, -1, []error{err}
 		}
-		if err = rs.OpenOutput(); err != nil {
+		if err = run.rs.OpenOutput(); err != nil {
 			return xlsFilepath, -1, []error{err}
 		}
 	}
-	if errs := processAttrs("", jsonCols, pack, rs); len(errs) > 0 {
-		success = -3
+	errs := processAttrs(run, "", jsonCols, pack, run.rs)
+	// the row is kept, so the next packs stay in their rows
+	run.rs.newLine()
+	if len(errs) > 0 {
 		return xlsFilepath, -1, errs
 	}
-	rs.newLine()
 	return xlsFilepath, success, nil
 }
 
-func processCategs(lines []lineT, wrCateg *jsonWriter, wrSeries *jsonWriter, idField string, categFields []string, categSeason int, forceGenteCategs bool) (int, []error) {
-	log("Processando categorias...")
+func processCategs(run *runT, lines []lineT, wrCateg *jsonWriter, wrSeries *jsonWriter, idField string, categFields []string, categSeason int, forceGenteCategs bool) (int, []error) {
+	log(msg(msgProcessingCategs))
 	success := 0
 	errors := make([]error, 0, 0)
 	for k := range lines {
@@ -437,8 +716,21 @@ func processCategs(lines []lineT, wrCateg *jsonWriter, wrSeries *jsonWriter, idF
 	return success, errors
 }
 
-func processSeries(pack []lineT, wrSeries *jsonWriter, idField string) (int, []error) {
-	log("Processando series...")
+// processCategTrees adds the rows to the category trees of the config
+func processCategTrees(lines []lineT, wrCateg *jsonWriter, trees []categTreeT, idField string, genresOnly bool) (int, []error) {
+	log(msg(msgProcessingCategs))
+	for k := range lines {
+		for i := range trees {
+			if err := wrCateg.processCategTree(&trees[i], &lines[k], idField, genresOnly); err != nil {
+				return -1, []error{err}
+			}
+		}
+	}
+	return 0, nil
+}
+
+func processSeries(run *runT, pack []lineT, wrSeries *jsonWriter, idField string) (int, []error) {
+	log(msg(msgProcessingSeries))
 	errors := make([]error, 0, 0)
 	for k := range pack {
 		row := pack[k]
@@ -452,22 +744,6 @@ func processSeries(pack []lineT, wrSeries *jsonWriter, idField string) (int, []e
 	return 0, errors
 }
 
-// init option vars
-func initVars(json map[string]interface{}) {
-	initFunctions()
-	options = make(optionsT)
-	options["options"] = make(map[string]string)
-	opts := json["options"].([]interface{})
-	for _, el := range opts {
-		// fmt.Printf(">>>> %T\n", el)
-		m := el.(map[string]interface{})
-		name := m["Name"].(string)
-		value := m["Value"].(string)
-		options["options"][name] = value
-	}
-	options["options"]["timestamp"] = timestamp()
-}
-
 // Reads the spreadsheet as an array of map[<line name>] = <value>
 func readSheetByName(f *xlsx.Spreadsheet, sName string) ([]lineT, error) {
 	header := make([]string, 0)
@@ -476,11 +752,26 @@ func readSheetByName(f *xlsx.Spreadsheet, sName string) ([]lineT, error) {
 	var sheet xlsx.Sheet
 	// reads sheet in stream mode (much faster)
 	if sheet = f.SheetByName(sName, xlsx.SheetModeStream); sheet == nil {
-		return nil, fmt.Errorf("aba nao existente na planilha: [%s]", sName)
+		return nil, newError(msgSheetNotFound, sName)
 	}
 	return readSheet(sheet, header, idx)
 }
 
+// sheetHeader returns the column names of a sheet read by readSheet, sorted
+func sheetHeader(lines []lineT) []string {
+	header := make([]string, 0)
+	if len(lines) == 0 {
+		return header
+	}
+	for k := range lines[0].fields {
+		if k != "file_number" {
+			header = append(header, k)
+		}
+	}
+	sort.Strings(header)
+	return header
+}
+
 // Returns true if a line has all fields blank
 func blankLine(line map[string]string) bool {
 	for k, v := range line {
@@ -522,14 +813,17 @@ func readSheet(sheet xlsx.Sheet, header []string, idx int) ([]lineT, error) {
 		colCell := sheet.Cell(col, 0)
 		hName := strings.ToLower(strings.TrimSpace(colCell.String()))
 		if contains(header, hName) {
-			return nil, fmt.Errorf("header da planilha duplicado: [%s]", hName)
+			return nil, newError(msgDuplicateHeader, hName)
 		}
 		header = append(header, hName)
 	}
 	// Reading other lines
+	info := &sheetInfoT{name: sheet.Name(), header: header}
 	lines := make([]lineT, 0)
-	line := newLineT(1)
+	line := newLineT(0)
+	line.sheet = info
 	for row := 1; row < nrows && empty < 5; row++ {
+		line.idx = row + 1
 		for c := 0; c < lastCol+1; c++ {
 			colCell := sheet.Cell(c, row)
 			cellF := ""
@@ -565,12 +859,12 @@ func readSheet(sheet xlsx.Sheet, header []string, idx int) ([]lineT, error) {
 		if !blankLine(line.fields) {
 			line.fields["file_number"] = fmt.Sprintf("%d", idx)
 			lines = append(lines, line)
-			line = newLineT(row)
+			line = newLineT(0)
+			line.sheet = info
 			idx++
 		}
 	}
-	log(fmt.Sprintf("Aba [%s]: %d linhas, %d colunas. Lidas: %d linhas, %d colunas.",
-		sheet.Name(), nrows, ncols, idx, lastCol+1))
+	log(msg(msgSheetRead, sheet.Name(), nrows, ncols, idx, lastCol+1))
 	return lines, nil
 }
 
@@ -595,31 +889,35 @@ func readConfig(confFile string) (map[string]interface{}, error) {
 }
 
 // Factory for creating the writer
-func createWriter(outType string, filename string, sheetname string, ncols int, nlines int, linesCateg []lineT, linesSeries []lineT, jType int) (writer, error) {
+func createWriter(run *runT, outType string, filename string, sheetname string, ncols int, nlines int, linesCateg []lineT, linesSeries []lineT, jType int) (writer, error) {
 	var err error
 	var wr writer
 	switch outType {
 	case "xml":
-		systemID, ok := options["options"]["doctype_system"]
+		systemID, ok := run.options["options"]["doctype_system"]
 		if !ok {
-			return nil, fmt.Errorf("acrescente a opcao 'doctype_system' no arquivo de config")
+			return nil, newError(msgDoctypeSystem)
 		}
-		wr, err = newXMLWriter(filename, systemID)
+		wr, err = newXMLWriter(run, filename, systemID)
+	case "adi3":
+		wr, err = newADI3Writer(run, filename)
+	case "mrss":
+		wr, err = newMRSSWriter(run, filename)
 	case "json":
-		wr, err = newJSONWriter(filename, linesCateg, linesSeries, jType)
+		wr, err = newJSONWriter(run, filename, linesCateg, linesSeries, jType)
 	case "report":
-		wr, err = newReportSheet(filename, sheetname, ncols, nlines)
+		wr, err = newReportSheet(run, filename, sheetname, ncols, nlines)
 	}
 	return wr, err
 }
 
 // Process the config file against the lines of the sheet
-func processAssets(json jsonT, lines []lineT, wr writer) (errs []error) {
+func processAssets(run *runT, json jsonT, lines []lineT, wr writer) (errs []error) {
 	if err := wr.OpenOutput(); err != nil {
 		return appendErrors("", errs, err)
 	}
 	// fmt.Println("----------")
-	errs = appendErrors("", errs, processMap(json, lines, wr)...)
+	errs = appendErrors("", errs, processMap(run, json, lines, wr)...)
 	errors := len(errs) > 0
 	rightFile := wr.Filename() + wr.Suffix()             // filename in case of success
 	wrongFile := wr.Filename() + errSuffix + wr.Suffix() // filename in case of errors
@@ -638,16 +936,28 @@ func processAssets(json jsonT, lines []lineT, wr writer) (errs []error) {
 	if err1 := wr.WriteAndClose(fileOut); err1 != nil {
 		return appendErrors("", errs, err1)
 	}
+	// Validate the XML against its DTD / schema
+	if xw, isXML := unwrapWriter(wr).(*xmlWriter); isXML && !wr.Testing() {
+		vErrs := validateXML(xw.getBuffer())
+		for _, e := range vErrs {
+			errs = append(errs, &cellErrorT{err: e, name: path.Base(fileOut), function: "validate", line: &lines[0]})
+		}
+		if len(vErrs) > 0 && fileOut == rightFile {
+			if err1 := run.renameOutput(rightFile, wrongFile); err1 != nil {
+				return appendErrors("", errs, err1)
+			}
+		}
+	}
 	return
 }
 
 // Process a JSON map element
-func processMap(json jsonT, lines []lineT, wr writer) (err2 []error) {
+func processMap(run *runT, json jsonT, lines []lineT, wr writer) (err2 []error) {
 	var name string
 	var hasName bool
 	if name, hasName = json["Name"].(string); !hasName {
 		if name != "" {
-			log(fmt.Sprintf("name not found: [%s]\n", name))
+			log(msg(msgNameNotFound, name))
 		}
 	}
 	commonAttrs, _ := json["common_attrs"].(map[string]interface{})
@@ -706,18 +1016,18 @@ func processMap(json jsonT, lines []lineT, wr writer) (err2 []error) {
 	// Default Attributes
 	if at, ok := json["attrs"]; ok {
 		attrs := at.([]interface{})
-		err2 = appendErrors(name, err2, processAttrs(name, attrs, lines, wr)...)
+		err2 = appendErrors(name, err2, processAttrs(run, name, attrs, lines, wr)...)
 	}
 	if atgr, ok := json["group_attrs"]; ok {
 		attrs := atgr.([]interface{})
-		err2 = appendErrors(name, err2, processGroupAttrs(name, attrs, lines, wr)...)
+		err2 = appendErrors(name, err2, processGroupAttrs(run, name, attrs, lines, wr)...)
 	}
 	if okSattr {
 		sAttrs := sAux.([]interface{})
-		err2 = appendErrors(name, err2, processSingleAttrs(name, sAttrs, lines, commonAttrs, wr)...)
+		err2 = appendErrors(name, err2, processSingleAttrs(run, name, sAttrs, lines, commonAttrs, wr)...)
 	}
 	if len(elements) > 0 && (okEl || okElArray) {
-		err2 = appendErrors(name, err2, processArray(name, elements, lines, wr)...)
+		err2 = appendErrors(name, err2, processArray(run, name, elements, lines, wr)...)
 	}
 	// Comment section
 	if co, ok := json["comments"]; ok {
@@ -725,7 +1035,7 @@ func processMap(json jsonT, lines []lineT, wr writer) (err2 []error) {
 			return
 		}
 		comm := co.([]interface{})
-		err2 = appendErrors(name, err2, processSingleElements(name, comm, lines, wr)...)
+		err2 = appendErrors(name, err2, processSingleElements(run, name, comm, lines, wr)...)
 		if err2 = appendErrors(name, err2, wr.EndComment("DTH")); len(err2) > 0 {
 			return
 		}
@@ -749,7 +1059,7 @@ func processMap(json jsonT, lines []lineT, wr writer) (err2 []error) {
 		case []map[string]interface{}:
 			fmt.Println(k, ":")
 			for _, u := range vv {
-				err2 = appendErrors(name, err2, processMap(u, lines, wr)...)
+				err2 = appendErrors(name, err2, processMap(run, u, lines, wr)...)
 			}
 		case []interface{}:
 			// fmt.Printf("%s:", k)
@@ -758,7 +1068,7 @@ func processMap(json jsonT, lines []lineT, wr writer) (err2 []error) {
 		case map[string]interface{}:
 			switch k {
 			case "options":
-				err2 = appendErrors(name, err2, processOptions(vv))
+				err2 = appendErrors(name, err2, processOptions(run, vv))
 			}
 		default:
 			// fmt.Printf("\n%v is type %T\n", k, v)
@@ -768,30 +1078,30 @@ func processMap(json jsonT, lines []lineT, wr writer) (err2 []error) {
 }
 
 // Process option section in the JSON
-func processOptions(json jsonT) error {
+func processOptions(run *runT, json jsonT) error {
 	for k, v := range json {
 		switch vv := v.(type) {
 		case string:
-			options["options"][k] = vv
+			run.options["options"][k] = vv
 		default:
-			return fmt.Errorf("opcao tem que ser string, chave: [%s]", k)
+			return newError(msgOptionNotString, k)
 		}
 	}
 	return nil
 }
 
 // Process attr element in the JSON
-func processAttrs(_ string, json []interface{}, lines []lineT, wr writer) (err2 []error) {
+func processAttrs(run *runT, _ string, json []interface{}, lines []lineT, wr writer) (err2 []error) {
 	for _, v := range json {
 		switch vv := v.(type) {
 		case map[string]interface{}:
-			err2 = appendErrors("", err2, processAttr(vv, lines, wr)...)
+			err2 = appendErrors("", err2, processAttr(run, vv, lines, wr)...)
 			if _, okEl := vv["elements"]; okEl {
-				err2 = appendErrors("", err2, processMap(vv, lines, wr)...)
+				err2 = appendErrors("", err2, processMap(run, vv, lines, wr)...)
 				continue
 			}
 			if _, okElArr := vv["elements_array"]; okElArr {
-				err2 = appendErrors("", err2, processMap(vv, lines, wr)...)
+				err2 = appendErrors("", err2, processMap(run, vv, lines, wr)...)
 				continue
 			}
 		}
@@ -800,7 +1110,7 @@ func processAttrs(_ string, json []interface{}, lines []lineT, wr writer) (err2
 }
 
 // Process group of attrs in the JSON
-func processGroupAttrs(_ string, json []interface{}, lines []lineT, wr writer) (err2 []error) {
+func processGroupAttrs(run *runT, _ string, json []interface{}, lines []lineT, wr writer) (err2 []error) {
 	if err := wr.StartElem("a", mapArrayT); err != nil {
 		err2 = appendErrors("", err2, err)
 		return
@@ -809,14 +1119,14 @@ func processGroupAttrs(_ string, json []interface{}, lines []lineT, wr writer) (
 		switch vv := v.(type) {
 		case map[string]interface{}:
 			if _, okEl := vv["elements"]; okEl {
-				err2 = appendErrors("", err2, processMap(vv, lines, wr)...)
+				err2 = appendErrors("", err2, processMap(run, vv, lines, wr)...)
 				continue
 			}
 			if _, okElArr := vv["elements_array"]; okElArr {
-				err2 = appendErrors("", err2, processMap(vv, lines, wr)...)
+				err2 = appendErrors("", err2, processMap(run, vv, lines, wr)...)
 				continue
 			}
-			err2 = appendErrors("", err2, processAttr(vv, lines, wr)...)
+			err2 = appendErrors("", err2, processAttr(run, vv, lines, wr)...)
 		}
 	}
 	err2 = appendErrors("", err2, wr.EndElem("a", mapArrayT))
@@ -846,14 +1156,14 @@ func populateOptions(vars map[string]string, options optionsT, key string) {
 }
 
 // Process attr element
-func processAttr(json jsonT, lines []lineT, wr writer) (errs []error) {
+func processAttr(run *runT, json jsonT, lines []lineT, wr writer) (errs []error) {
 	var name string
 	name, _ = json["Name"].(string)
 	attrType, _ := json["at_type"].(string)
 	function, ok := json["function"].(string)
 	if !ok {
 		// element does not have "function" attribute
-		return []error{fmt.Errorf("elemento [%s] nao tem 'function'", name)}
+		return []error{newError(msgElementNoFunction, name)}
 	}
 	if function == "empty" {
 		// function empty does nothing
@@ -873,10 +1183,13 @@ func processAttr(json jsonT, lines []lineT, wr writer) (errs []error) {
 		}
 	}
 	// process function
-	procVals, err2 := process(function, lines, json, options)
+	procVals, err2 := process(run, function, lines, json)
 	errs = appendErrors(name, errs, err2)
+	if run.packages != nil {
+		run.packages.addMedia(function, name, procVals)
+	}
 	for _, procVal := range procVals {
-		populateOptions(procVal.vars, options, "options")
+		populateOptions(procVal.vars, run.options, "options")
 		isOtt := attrType == "ott"
 		if isOtt {
 			// Ott type open a new element, line <elem>x<elem>
@@ -884,12 +1197,13 @@ func processAttr(json jsonT, lines []lineT, wr writer) (errs []error) {
 		}
 		if at, okA := json["attrs"]; okA {
 			attrs := at.([]interface{})
-			errs = appendErrors(name, errs, processAttrs(name, attrs, lines, wr)...)
+			errs = appendErrors(name, errs, processAttrs(run, name, attrs, lines, wr)...)
 		}
 		if f2, okf2 := json["function2"]; !okf2 || f2 != "set_var" { // test set_var
 			vtype, _ := json["type"].(string)
 			if err1 := wr.WriteAttr(name, processVal(procVal.val, procVal.vars), vtype, attrType); err1 != nil {
-				errs = appendErrors(name, errs, err1)
+				// conversion errors are recorded against the row
+				errs = append(errs, newCellError(err1, &lines[0], json, function))
 			}
 		}
 		if isOtt {
@@ -901,17 +1215,17 @@ func processAttr(json jsonT, lines []lineT, wr writer) (errs []error) {
 }
 
 // Process singleT attrs = one attr per line
-func processSingleAttrs(name string, json []interface{}, lines []lineT, commonAttrs map[string]interface{}, wr writer) (err2 []error) {
+func processSingleAttrs(run *runT, name string, json []interface{}, lines []lineT, commonAttrs map[string]interface{}, wr writer) (err2 []error) {
 	for _, v := range json {
 		switch vv := v.(type) {
 		case map[string]interface{}:
-			err2 = appendErrors("", err2, processSingleAttr(name, vv, lines, commonAttrs, wr)...)
+			err2 = appendErrors("", err2, processSingleAttr(run, name, vv, lines, commonAttrs, wr)...)
 		}
 	}
 	return
 }
 
-func processSingleAttr(nameElem string, json jsonT, lines []lineT, commonAttrs map[string]interface{}, wr writer) (errs []error) {
+func processSingleAttr(run *runT, nameElem string, json jsonT, lines []lineT, commonAttrs map[string]interface{}, wr writer) (errs []error) {
 	_, okFil := json["filter"].(string)
 	var function string
 	var okFun bool
@@ -925,10 +1239,10 @@ func processSingleAttr(nameElem string, json jsonT, lines []lineT, commonAttrs m
 	name, _ := json["Name"].(string)
 	if !okFun {
 		value, _ := json["Value"].(string)
-		errs = []error{fmt.Errorf("erro no atributo %s, value [%s]", name, value)}
+		errs = []error{newError(msgAttributeError, name, value)}
 	}
 	var err3 error
-	if procVals, err3 = process(function, lines, json, options); err3 != nil {
+	if procVals, err3 = process(run, function, lines, json); err3 != nil {
 		return appendErrors("", errs, err3)
 	}
 	isOtt := false
@@ -945,7 +1259,7 @@ func processSingleAttr(nameElem string, json jsonT, lines []lineT, commonAttrs m
 			if oka {
 				attrs = at.([]interface{})
 			}
-			errs, done = writeElem(wr, attrs, lines, name, procVal.val)
+			errs, done = writeElem(run, wr, attrs, lines, name, procVal.val)
 		} else {
 			vtype, _ := json["type"].(string)
 			errs, done = writeAttr(wr, nameElem, commonAttrs, name, procVal.val, vtype, elType)
@@ -955,17 +1269,17 @@ func processSingleAttr(nameElem string, json jsonT, lines []lineT, commonAttrs m
 		}
 	}
 	if attrs, ok := json["single_attrs"].([]interface{}); ok {
-		processAttrs("", attrs, lines, wr)
+		processAttrs(run, "", attrs, lines, wr)
 		return nil
 	}
 	return
 }
 
 // Write element to output
-func writeElem(wr writer, attrs []interface{}, lines []lineT, name string, procVal string) (errs []error, done bool) {
+func writeElem(run *runT, wr writer, attrs []interface{}, lines []lineT, name string, procVal string) (errs []error, done bool) {
 	done = true
 	var processed []error
-	if processed = processAttrs(name, attrs, lines, wr); len(processed) > 0 {
+	if processed = processAttrs(run, name, attrs, lines, wr); len(processed) > 0 {
 		return processed, false
 	}
 	if errs = appendErrors(name, errs, wr.StartElem(name, singleT)); len(errs) > 0 {
@@ -1010,15 +1324,15 @@ func writeAttr(wr writer, nameElem string, commonAttrs map[string]interface{}, n
 	return nil, false
 }
 
-func processArray(_ string, json []interface{}, lines []lineT, wr writer) (err2 []error) {
+func processArray(run *runT, _ string, json []interface{}, lines []lineT, wr writer) (err2 []error) {
 	//fmt.Printf(">>>%s<<<\n", nameElem)
 	// w.StartElem(xw.Elem{Name: nameElem})
 	for _, v := range json {
 		switch vv := v.(type) {
 		case map[string]interface{}:
-			err2 = appendErrors("", err2, processMap(vv, lines, wr)...)
+			err2 = appendErrors("", err2, processMap(run, vv, lines, wr)...)
 		case []interface{}:
-			err2 = appendErrors("", err2, processArray("", vv, lines, wr)...)
+			err2 = appendErrors("", err2, processArray(run, "", vv, lines, wr)...)
 		default:
 			// fmt.Printf("\n%v is type %T\n", k, v)
 		}
@@ -1027,30 +1341,30 @@ func processArray(_ string, json []interface{}, lines []lineT, wr writer) (err2
 	return
 }
 
-func processSingleElements(_ string, json []interface{}, lines []lineT, wr writer) (err2 []error) {
+func processSingleElements(run *runT, _ string, json []interface{}, lines []lineT, wr writer) (err2 []error) {
 	for _, v := range json {
 		switch vv := v.(type) {
 		case map[string]interface{}:
 			if _, ok := vv["elements2"]; ok {
-				err2 = appendErrors("", err2, processMap(vv, lines, wr)...)
+				err2 = appendErrors("", err2, processMap(run, vv, lines, wr)...)
 				continue
 			}
-			err2 = appendErrors("", err2, processSingleElement(vv, lines, wr)...)
+			err2 = appendErrors("", err2, processSingleElement(run, vv, lines, wr)...)
 		}
 	}
 	return
 }
 
-func processSingleElement(json jsonT, lines []lineT, wr writer) (errs []error) {
+func processSingleElement(run *runT, json jsonT, lines []lineT, wr writer) (errs []error) {
 	var name string
 	name, _ = json["Name"].(string)
 	function, ok := json["function"].(string)
 	if !ok {
-		return []error{fmt.Errorf("elemento sem atributo 'function': [%s]", name)}
+		return []error{newError(msgElementWithoutFunc, name)}
 	}
 	var procVals []resultsT
 	var errsp error
-	if procVals, errsp = process(function, lines, json, options); errsp != nil {
+	if procVals, errsp = process(run, function, lines, json); errsp != nil {
 		return appendErrors("", errs, errsp)
 	}
 	if errs = appendErrors("", errs, wr.StartElem(name, singleT)); len(errs) > 0 {
@@ -1079,8 +1393,7 @@ func latinToUTF8(buffer []byte) string {
 }
 
 func logError(err error) {
-	msg := fmt.Sprintf("ERRO: %v", err.Error())
-	log(msg)
+	log(msg(msgLogError, err))
 }
 
 func log(msg string) {
@@ -1094,8 +1407,12 @@ func appendErrors(name string, result []error, errors ...error) []error {
 		}
 		if name == "" {
 			result = append(result, e)
+		} else if cellErr, ok := e.(*cellErrorT); ok {
+			// keeps the error coordinates, adding the element to its path
+			cellErr.path = append([]string{name}, cellErr.path...)
+			result = append(result, cellErr)
 		} else {
-			result = append(result, fmt.Errorf("[%s] %v", name, e.Error()))
+			result = append(result, fmt.Errorf("[%s] %w", name, e))
 		}
 	}
 	return result
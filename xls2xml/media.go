@@ -0,0 +1,303 @@
+package main
+
+import (
+	"crypto/md5"
+	js "encoding/json"
+	"fmt"
+	"image"
+	_ "image/jpeg" // image formats read by the image functions
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Options of the media files
+const (
+	mediaRootOpt     = "media_root"     // directory of the media files, overridden by -mediaroot
+	checksumCacheOpt = "checksum_cache" // file with the checksums computed before. Default: cache only in memory
+)
+
+// checksumEntryT is a checksum computed before, valid while the size and the modification time of
+// the file don't change
+type checksumEntryT struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"` // unix nanoseconds
+	MD5     string `json:"md5"`
+}
+
+// checksumCacheT holds the checksums of the media files, so big files are not hashed again in each run
+type checksumCacheT struct {
+	file    string
+	entries map[string]checksumEntryT // absolute path -> checksum
+	changed bool
+	mutex   sync.Mutex
+}
+
+// checksumCache returns the checksum cache of the run, read when first used. The cache is kept in a file
+// only if the option 'checksum_cache' is set
+func (r *runT) checksumCache() (*checksumCacheT, error) {
+	if r.checksums != nil {
+		return r.checksums, nil
+	}
+	c, err := newChecksumCache(r.options["options"][checksumCacheOpt])
+	if err != nil {
+		return nil, err
+	}
+	r.checksums = c
+	return c, nil
+}
+
+// saveChecksumCache writes the checksum cache of the run, if it was used
+func (r *runT) saveChecksumCache() error {
+	if r.checksums == nil {
+		return nil
+	}
+	return r.checksums.save()
+}
+
+// newChecksumCache reads a cache file
+func newChecksumCache(file string) (*checksumCacheT, error) {
+	c := &checksumCacheT{file: file, entries: make(map[string]checksumEntryT)}
+	if c.file == "" {
+		// cache only in memory
+		return c, nil
+	}
+	if err := readJSONFile(c.file, &c.entries, msgChecksumCache); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// md5 returns the MD5 of a file, in uppercase hex
+func (c *checksumCacheT) md5(filename string, st os.FileInfo) (string, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	c.mutex.Lock()
+	e, ok := c.entries[abs]
+	c.mutex.Unlock()
+	if ok && e.Size == st.Size() && e.ModTime == st.ModTime().UnixNano() {
+		return e.MD5, nil
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", newError(msgMediaNotFound, filename, err)
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := strings.ToUpper(fmt.Sprintf("%x", h.Sum(nil)))
+	c.mutex.Lock()
+	c.entries[abs] = checksumEntryT{Size: st.Size(), ModTime: st.ModTime().UnixNano(), MD5: sum}
+	c.changed = true
+	c.mutex.Unlock()
+	return sum, nil
+}
+
+// save writes the cache, if new checksums were computed
+func (c *checksumCacheT) save() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.file == "" || !c.changed {
+		return nil
+	}
+	buf, err := js.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(c.file), 0755); err != nil {
+		return newError(msgCreateFile, c.file, err)
+	}
+	if err = ioutil.WriteFile(c.file, buf, 0644); err != nil {
+		return newError(msgCreateFile, c.file, err)
+	}
+	c.changed = false
+	return nil
+}
+
+// mediaFile returns the path and the info of the media file of a line. The file name is built from the
+// column 'file_field' like in the function field_suffix, relative to the media root
+func mediaFile(line *lineT, json jsonT, options optionsT) (string, os.FileInfo, error) {
+	root := options["options"][mediaRootOpt]
+	if root == "" {
+		return "", nil, newError(msgMediaRootMissing)
+	}
+	fileField, _ := json["file_field"].(string)
+	if fileField == "" {
+		return "", nil, newError(msgFileFieldMissing, json["Name"])
+	}
+	jsonF := jsonT{"field": fileField}
+	for _, key := range []string{"suffix", "field_prefix"} {
+		if v, ok := json[key]; ok {
+			jsonF[key] = v
+		}
+	}
+	name, err := pathSuffix("", line, jsonF, options)
+	if err != nil {
+		return "", nil, err
+	}
+	filename := filepath.Join(root, filepath.FromSlash(name[0].val))
+	st, err := os.Stat(filename)
+	if err != nil {
+		return filename, nil, newError(msgMediaNotFound, filename, err)
+	}
+	return filename, st, nil
+}
+
+// mediaSize returns the size of the media file of the line. The value of the column 'field', if
+// given, must be the same
+func mediaSize(forceVal string, line *lineT, json jsonT, options optionsT) ([]resultsT, error) {
+	if forceVal != "" {
+		return []resultsT{newResult(forceVal)}, nil
+	}
+	filename, st, err := mediaFile(line, json, options)
+	if err != nil {
+		return errorMessage, err
+	}
+	size := strconv.FormatInt(st.Size(), 10)
+	return checkMediaValue(size, filename, line, json, func(given string) bool {
+		// the column may be numeric in the spreadsheet
+		f, errF := strconv.ParseFloat(given, 64)
+		return errF == nil && f == float64(st.Size())
+	})
+}
+
+// mediaMD5 returns the MD5 of the media file of the line. The value of the column 'field', if given,
+// must be the same. The runs use their checksum cache, see runT.mediaMD5
+func mediaMD5(forceVal string, line *lineT, json jsonT, options optionsT) ([]resultsT, error) {
+	cache, _ := newChecksumCache("")
+	return checksumMD5(cache, forceVal, line, json, options)
+}
+
+// mediaMD5 is the function media_md5 of the run, using its checksum cache
+func (r *runT) mediaMD5(forceVal string, line *lineT, json jsonT, options optionsT) ([]resultsT, error) {
+	cache, err := r.checksumCache()
+	if err != nil {
+		return errorMessage, err
+	}
+	return checksumMD5(cache, forceVal, line, json, options)
+}
+
+// checksumMD5 returns the MD5 of the media file of the line, from the cache if the file did not change
+func checksumMD5(cache *checksumCacheT, forceVal string, line *lineT, json jsonT, options optionsT) ([]resultsT, error) {
+	if forceVal != "" {
+		return []resultsT{newResult(forceVal)}, nil
+	}
+	filename, st, err := mediaFile(line, json, options)
+	if err != nil {
+		return errorMessage, err
+	}
+	sum, err := cache.md5(filename, st)
+	if err != nil {
+		return errorMessage, err
+	}
+	return checkMediaValue(sum, filename, line, json, func(given string) bool {
+		return strings.EqualFold(given, sum)
+	})
+}
+
+// checkMediaValue compares a value computed from a media file with the value of the column 'field'.
+// Mismatches are errors, returning the computed value
+func checkMediaValue(value string, filename string, line *lineT, json jsonT, same func(string) bool) ([]resultsT, error) {
+	field, _ := json["field"].(string)
+	if field == "" {
+		return []resultsT{newResult(value)}, nil
+	}
+	given := strings.TrimSpace(line.fields[strings.ToLower(field)])
+	if given != "" && given != value && !same(given) {
+		return []resultsT{newResult(value)}, newError(msgMediaMismatch, given, value, filename)
+	}
+	return []resultsT{newResult(value)}, nil
+}
+
+// Tolerance of the aspect ratio of the images
+const aspectTolerance = 0.01
+
+// imageSize returns the dimensions of the JPEG or PNG media file of the line, checking the constraints
+// 'min_width', 'min_height' and 'aspect' (like "16:9") of the element. The dimensions are returned
+// with the errors of the constraints
+func imageSize(line *lineT, json jsonT, options optionsT) (int, int, error) {
+	filename, _, err := mediaFile(line, json, options)
+	if err != nil {
+		return 0, 0, err
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, 0, newError(msgMediaNotFound, filename, err)
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, newError(msgImageFormat, filename, err)
+	}
+	w, h := cfg.Width, cfg.Height
+	minW, minH := 0, 0
+	for key, val := range map[string]*int{"min_width": &minW, "min_height": &minH} {
+		if s, ok := json[key].(string); ok && s != "" {
+			if *val, err = strconv.Atoi(s); err != nil {
+				return w, h, newError(msgImageConstraint, key, s)
+			}
+		}
+	}
+	if w < minW || h < minH {
+		return w, h, newError(msgImageTooSmall, filename, w, h, minW, minH)
+	}
+	if aspect, _ := json["aspect"].(string); aspect != "" {
+		parts := strings.Split(aspect, ":")
+		if len(parts) != 2 {
+			return w, h, newError(msgImageConstraint, "aspect", aspect)
+		}
+		aw, errW := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		ah, errH := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if errW != nil || errH != nil || aw <= 0 || ah <= 0 {
+			return w, h, newError(msgImageConstraint, "aspect", aspect)
+		}
+		if h == 0 || math.Abs(float64(w)/float64(h)-aw/ah) > aspectTolerance*aw/ah {
+			return w, h, newError(msgImageAspect, filename, w, h, aspect)
+		}
+	}
+	return w, h, nil
+}
+
+// imageResult returns the value of an image function
+func imageResult(line *lineT, json jsonT, options optionsT, value func(w, h int) string) ([]resultsT, error) {
+	w, h, err := imageSize(line, json, options)
+	if w == 0 && h == 0 && err != nil {
+		return errorMessage, err
+	}
+	return []resultsT{newResult(value(w, h))}, err
+}
+
+// mediaWidth returns the width of the image file of the line
+func mediaWidth(forceVal string, line *lineT, json jsonT, options optionsT) ([]resultsT, error) {
+	if forceVal != "" {
+		return []resultsT{newResult(forceVal)}, nil
+	}
+	return imageResult(line, json, options, func(w, _ int) string { return strconv.Itoa(w) })
+}
+
+// mediaHeight returns the height of the image file of the line
+func mediaHeight(forceVal string, line *lineT, json jsonT, options optionsT) ([]resultsT, error) {
+	if forceVal != "" {
+		return []resultsT{newResult(forceVal)}, nil
+	}
+	return imageResult(line, json, options, func(_, h int) string { return strconv.Itoa(h) })
+}
+
+// mediaResolution returns the resolution of the image file of the line (<width>x<height>), the format
+// of Image_Aspect_Ratio
+func mediaResolution(forceVal string, line *lineT, json jsonT, options optionsT) ([]resultsT, error) {
+	if forceVal != "" {
+		return []resultsT{newResult(forceVal)}, nil
+	}
+	return imageResult(line, json, options, func(w, h int) string { return fmt.Sprintf("%dx%d", w, h) })
+}
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+)
+
+// Languages of the message catalog, selected with -lang
+const (
+	langPT = "pt-BR"
+	langEN = "en"
+)
+
+// lang is the language of the log and error messages, set by -lang before the run starts
+var lang = langPT
+
+// Message codes. The codes are stable, whatever the language: they are written in the error report and
+// may be used by integrations to identify the errors.
+// I: information, W: warning, U: command line usage, E1: command line and input spreadsheets, E2: config,
+// E3: values of the spreadsheet, E4: output files, E5: validation of the output, E6: rules and uniqueness
+const (
+	msgStart            = "I001"
+	msgSuccess          = "I002"
+	msgFailureBanner1   = "I003"
+	msgFailureBanner2   = "I004"
+	msgInputSheet       = "I005"
+	msgConfigFile       = "I006"
+	msgOutputDir        = "I007"
+	msgCategSheet       = "I008"
+	msgStrictCancelled  = "I009"
+	msgAnnotatedWritten = "I010"
+	msgReportWritten    = "I011"
+	msgGenerating       = "I012"
+	msgProcessingLine   = "I013"
+	msgFile             = "I014"
+	msgWriting          = "I015"
+	msgSaving           = "I016"
+	msgProcessingCategs = "I017"
+	msgProcessingSeries = "I018"
+	msgSheetRead        = "I019"
+	msgNewCateg         = "I020"
+	msgAddingCateg      = "I021"
+	msgLogError         = "I022"
+	msgLogWarning       = "I023"
+	msgRuleName         = "I024"
+	msgConditionNotMet  = "I025"
+	msgOr               = "I026"
+	msgVersionUpdate    = "I027"
+	msgVersionUnchanged = "I028"
+	msgVersionDelete    = "I029"
+	msgEPGChannel       = "I030"
+	msgPackaging        = "I031"
+	msgOutputRenamed    = "I032"
+	msgBoxMerging       = "I033"
+	msgBoxMerged        = "I034"
+	msgSeriesDerived    = "I035"
+
+	msgUnusedColumn     = "W001"
+	msgFunctionMissing  = "W002"
+	msgCategNotInSheet  = "W003"
+	msgColumnNotInSheet = "W004"
+	msgNameNotFound     = "W005"
+
+	msgFlagXls        = "U001"
+	msgFlagConfig     = "U002"
+	msgFlagOutType    = "U003"
+	msgFlagOutDir     = "U004"
+	msgFlagXlsCat     = "U005"
+	msgFlagGenreCat   = "U006"
+	msgFlagValidate   = "U007"
+	msgFlagStrict     = "U008"
+	msgFlagLang       = "U009"
+	msgUsageExitCodes = "U010"
+	msgFlagMediaRoot  = "U011"
+
+	msgXlsRequired      = "E101"
+	msgConfigRequired   = "E102"
+	msgInvalidOutType   = "E103"
+	msgInvalidOutDir    = "E104"
+	msgXlsCatRequired   = "E105"
+	msgInvalidLang      = "E106"
+	msgOpenSheet        = "E107"
+	msgSheetNotFound    = "E108"
+	msgDuplicateHeader  = "E109"
+	msgMissingColumn    = "E110"
+	msgMissingColumns   = "E111"
+	msgNoSeriesData     = "E112"
+	msgSeriesColumn     = "E113"
+	msgSeriesSheetError = "E114"
+	msgSeriesNoPtName   = "E115"
+
+	msgCategSeasonOption   = "E201"
+	msgFilenameFieldOption = "E202"
+	msgNameFieldOption     = "E203"
+	msgXlsOutputFilename   = "E204"
+	msgXlsOutputColumns    = "E205"
+	msgXlsOutputSheet      = "E206"
+	msgDoctypeSystem       = "E207"
+	msgOptionNotString     = "E208"
+	msgElementNoFunction   = "E209"
+	msgElementWithoutFunc  = "E210"
+	msgFunctionUnspecified = "E211"
+	msgFunctionNotDefined  = "E212"
+	msgFunctionUndefined   = "E213"
+	msgSeasonIdOption      = "E214"
+	msgSeriesTitleOption   = "E215"
+	msgSeasonNumOption     = "E216"
+	msgInvalidFunction2    = "E217"
+	msgFixedNoValue        = "E218"
+	msgAttrmapNoAttrs      = "E219"
+	msgAttrmapNoName       = "E220"
+	msgConvertNoFrom       = "E221"
+	msgConvertNoTo         = "E222"
+	msgConvertSizes        = "E223"
+	msgConditionNoFunction = "E224"
+	msgConditionRecursive  = "E225"
+	msgFieldDirMissing     = "E226"
+	msgSeasonFieldConfig   = "E227"
+	msgEpisodeFieldConfig  = "E228"
+	msgSeriesIdFieldConfig = "E229"
+	msgVarNotFound         = "E230"
+	msgOptionElement       = "E231"
+	msgOptionsElement      = "E232"
+	msgJSONKeyNotFound     = "E233"
+	msgAttributeError      = "E234"
+	msgOutputEncoding      = "E235"
+	msgMediaRootMissing    = "E236"
+	msgFileFieldMissing    = "E237"
+	msgImageConstraint     = "E238"
+	msgOptionValue         = "E239"
+	msgEPGSection          = "E240"
+	msgPackageOutType      = "E241"
+	msgCategTree           = "E242"
+	msgCategLevelName      = "E243"
+	msgCategLevelValue     = "E244"
+
+	msgFieldError             = "E301"
+	msgElementNotInLine       = "E302"
+	msgElementNotInLineV      = "E303"
+	msgElementNotInLineB      = "E304"
+	msgValidationFailed       = "E305"
+	msgMaxLengthNotNumeric    = "E306"
+	msgOriginalTitleMissing   = "E307"
+	msgSeasonMissing          = "E308"
+	msgEpisodeMissing         = "E309"
+	msgIdMissing              = "E310"
+	msgUnexpectedError        = "E311"
+	msgAssetPrefixMissing     = "E312"
+	msgProviderMissing        = "E313"
+	msgSuffixNumberMissing    = "E314"
+	msgTimestampMissing       = "E315"
+	msgFileNumberMissing      = "E316"
+	msgEpisodeSeasonMissing   = "E317"
+	msgEpisodeIdMissing       = "E318"
+	msgInvalidSeriesData      = "E319"
+	msgSeriesNotFound         = "E320"
+	msgSeasonFieldMissing     = "E321"
+	msgDateFormat             = "E322"
+	msgConditionElement       = "E323"
+	msgInvalidDate            = "E324"
+	msgExpressionElement      = "E325"
+	msgInvalidExpressionValue = "E326"
+	msgExpressionError        = "E327"
+	msgExpressionEvalFailed   = "E328"
+	msgConvertValueNotFound   = "E329"
+	msgInvalidExpression      = "E330"
+	msgInvalidExpressionArgs  = "E331"
+	msgUnknownTechnology      = "E332"
+	msgEmptyValue             = "E333"
+	msgInvalidBoolean         = "E334"
+	msgInvalidInsert          = "E335"
+	msgSeriesNotInSheet       = "E336"
+	msgInvalidSeriesValue     = "E337"
+	msgLanguageField          = "E338"
+	msgSeriesSeasonNotFound   = "E339"
+	msgCategNotFound          = "E340"
+	msgEmptyMoney             = "E341"
+	msgInvalidMoney           = "E342"
+	msgMoneyFormat            = "E343"
+	msgSuffixOverflow         = "E344"
+	msgEmptyDuration          = "E345"
+	msgNegativeDuration       = "E346"
+	msgDurationFormat         = "E347"
+	msgInvalidChars           = "E348"
+	msgConversionFailed       = "E349"
+	msgMediaNotFound          = "E350"
+	msgMediaMismatch          = "E351"
+	msgImageFormat            = "E352"
+	msgImageTooSmall          = "E353"
+	msgImageAspect            = "E354"
+	msgEPGTime                = "E355"
+	msgEPGEndBeforeStart      = "E356"
+	msgDerivedSeason          = "E357"
+	msgExprFunctionArgs       = "E358"
+
+	msgCreateFile       = "E401"
+	msgRenameFile       = "E402"
+	msgMoveFile         = "E403"
+	msgSentinelValue    = "E404"
+	msgFileIsDir        = "E405"
+	msgFileNotWritable  = "E406"
+	msgCellNotFound     = "E407"
+	msgCommentCell      = "E408"
+	msgFilenameNotFound = "E409"
+	msgChecksumCache    = "E410"
+	msgPackageFile      = "E411"
+	msgPackageChecksum  = "E412"
+	msgOutputExists     = "E413"
+	msgBoxCatalog       = "E414"
+
+	msgXMLMalformed       = "E501"
+	msgRootMismatch       = "E502"
+	msgTextNotAllowed     = "E503"
+	msgElementUndeclared  = "E504"
+	msgAttrUndeclared     = "E505"
+	msgAttrFixed          = "E506"
+	msgAttrEnum           = "E507"
+	msgAttrRequired       = "E508"
+	msgElementNotEmpty    = "E509"
+	msgElementNotAllowed  = "E510"
+	msgContentInvalid     = "E511"
+	msgMinOccurs          = "E512"
+	msgMaxOccurs          = "E513"
+	msgMaxLength          = "E514"
+	msgValueInvalid       = "E515"
+	msgValueEnum          = "E516"
+	msgUnsupportedCharset = "E517"
+	msgADI3Root           = "E518"
+	msgADI3Write          = "E519"
+	msgMRSSWrite          = "E520"
+	msgDTDElement         = "E521"
+	msgDTDAttlist         = "E522"
+	msgContentModel       = "E523"
+	msgContentModelRegexp = "E524"
+	msgSchemaJSON         = "E525"
+	msgJSONSchemaRef      = "E530"
+	msgJSONType           = "E531"
+	msgJSONEnum           = "E532"
+	msgJSONPattern        = "E533"
+	msgJSONRequired       = "E534"
+	msgCategAssetRef      = "E535"
+	msgCategSerieRef      = "E536"
+	msgCategParentRef     = "E537"
+
+	msgInvalidRule      = "E601"
+	msgRuleSeverity     = "E602"
+	msgRuleNoCondition  = "E603"
+	msgRuleExpression   = "E604"
+	msgRuleFilterFailed = "E605"
+	msgRuleFailed       = "E606"
+	msgRuleViolated     = "E607"
+	msgUniqueIndex      = "E608"
+	msgUniqueRepeated   = "E609"
+	msgUniqueDelivered  = "E610"
+	msgVersionRegistry  = "E611"
+	msgVersionNoPrev    = "E612"
+	msgVersionNumber    = "E613"
+	msgEPGOverlap       = "E614"
+	msgEPGGap           = "E615"
+	msgVersionKey       = "E616"
+)
+
+// messageT is a message of the catalog, in each language. The messages are fmt formats
+type messageT struct {
+	pt string
+	en string
+}
+
+// catalog holds the messages by code. The translations must have the same verbs, in the same order
+var catalog = map[string]messageT{
+	msgStart:            {" Iniciando processamento ", " Starting processing "},
+	msgSuccess:          {" Processamento terminado com sucesso. ", " Processing finished successfully. "},
+	msgFailureBanner1:   {"*    ATENCAO: ERROS NO PROCESSAMENTO      *", "*       WARNING: PROCESSING ERRORS        *"},
+	msgFailureBanner2:   {"*       VERIFIQUE MENSAGENS ACIMA         *", "*        CHECK THE MESSAGES ABOVE         *"},
+	msgInputSheet:       {"Planilha de entrada: [%s]", "Input spreadsheet: [%s]"},
+	msgConfigFile:       {"Arquivo config: [%s]", "Config file: [%s]"},
+	msgOutputDir:        {"Diretorio de saida: [%s]", "Output directory: [%s]"},
+	msgCategSheet:       {"Planilha de categorias: [%s]", "Categories spreadsheet: [%s]"},
+	msgStrictCancelled:  {"Modo estrito: lote cancelado por erros, nenhum arquivo foi gravado", "Strict mode: batch cancelled because of errors, no file was written"},
+	msgAnnotatedWritten: {"Gravada planilha anotada: %s", "Annotated spreadsheet written: %s"},
+	msgReportWritten:    {"Gravado arquivo de report: %s", "Report file written: %s"},
+	msgGenerating:       {"Iniciando geracao de arquivos:", "Starting file generation:"},
+	msgProcessingLine:   {"Processando linha %d...", "Processing line %d..."},
+	msgFile:             {"Arquivo: %s", "File: %s"},
+	msgWriting:          {"Escrevendo %s", "Writing %s"},
+	msgSaving:           {"Salvando %s", "Saving %s"},
+	msgProcessingCategs: {"Processando categorias...", "Processing categories..."},
+	msgProcessingSeries: {"Processando series...", "Processing series..."},
+	msgSheetRead:        {"Aba [%s]: %d linhas, %d colunas. Lidas: %d linhas, %d colunas.", "Sheet [%s]: %d rows, %d columns. Read: %d rows, %d columns."},
+	msgNewCateg:         {"criando nova categoria: [%s], id: [%s], parent:[%s]", "creating new category: [%s], id: [%s], parent:[%s]"},
+	msgAddingCateg:      {"adicionando: [%s], id: [%s]", "adding: [%s], id: [%s]"},
+	msgLogError:         {"ERRO: %v", "ERROR: %v"},
+	msgLogWarning:       {"WARNING: %v", "WARNING: %v"},
+	msgRuleName:         {"regra %d", "rule %d"},
+	msgConditionNotMet:  {"condicao nao satisfeita: %s", "condition not satisfied: %s"},
+	msgOr:               {" ou ", " or "},
+	msgVersionUpdate:    {"Atualizacao de [%s]: Version_Minor incrementado", "Update of [%s]: Version_Minor incremented"},
+	msgVersionUnchanged: {"Sem alteracoes desde a ultima entrega, nao entregue: [%s]", "No changes since the last delivery, not delivered: [%s]"},
+	msgVersionDelete:    {"Remocao de [%s]: pacote com Verb=\"DELETE\"", "Deletion of [%s]: package with Verb=\"DELETE\""},
+	msgEPGChannel:       {"Canal [%s]", "Channel [%s]"},
+	msgPackaging:        {"Empacotando [%s]", "Packaging [%s]"},
+	msgOutputRenamed:    {"Nome [%s] ja usado: gerando [%s]", "Name [%s] already used: writing [%s]"},
+	msgBoxMerging:       {"Mesclando com o catalogo anterior em [%s]", "Merging into the previous catalog in [%s]"},
+	msgBoxMerged:        {"[%s]: %d incluidos, %d atualizados, %d removidos", "[%s]: %d added, %d updated, %d removed"},
+	msgSeriesDerived:    {"Series da planilha principal: %d series, %d temporadas", "Series of the main sheet: %d series, %d seasons"},
+
+	msgUnusedColumn:     {"WARNING: coluna [%s] da aba '%s' nao e' usada pelo config", "WARNING: column [%s] of sheet '%s' is not used by the config"},
+	msgFunctionMissing:  {"Warning: funcao [%s] nao existe!", "Warning: function [%s] does not exist!"},
+	msgCategNotInSheet:  {"WARNING: categoria [%s] nao existente na aba 'categories'", "WARNING: category [%s] does not exist in sheet 'categories'"},
+	msgNameNotFound:     {"WARNING: nome nao encontrado: [%s]", "WARNING: name not found: [%s]"},
+	msgColumnNotInSheet: {"WARNING: coluna [%s] usada pelo config nao existe na aba '%s'", "WARNING: column [%s] used by the config does not exist in sheet '%s'"},
+
+	msgFlagXls:      {"Arquivo XLS de entrada", "Input XLS file"},
+	msgFlagConfig:   {"Arquivo JSON de configuracao", "JSON config file"},
+	msgFlagOutType:  {"Tipo de output (xml, adi3, mrss, json ou epg). Default: xml", "Output type (xml, adi3, mrss, json or epg). Default: xml"},
+	msgFlagOutDir:   {"Diretorio de saida", "Output directory"},
+	msgFlagXlsCat:   {"Arquivo Xls de categorias", "Categories XLS file"},
+	msgFlagGenreCat: {"So insere categorias que sao generos", "Only insert categories that are genres"},
+	msgFlagValidate: {"So verifica se as colunas usadas pelo config existem na planilha, sem gerar arquivos", "Only check that the columns used by the config exist in the spreadsheet, without generating files"},
+	msgFlagStrict:   {"Modo estrito: qualquer erro cancela o lote e nenhum arquivo e' gravado", "Strict mode: any error cancels the batch and no file is written"},
+	msgFlagLang:     {"Idioma das mensagens (pt-BR ou en)", "Language of the messages (pt-BR or en)"},
+	msgUsageExitCodes: {"Codigos de saida:\n" +
+		"  0  sucesso\n" +
+		"  1  linha de comando invalida\n" +
+		"  2  opcoes obrigatorias faltando no config\n" +
+		"  3  processamento terminado com erros: linhas nao geradas, arquivos _ERRO ou lote cancelado no modo estrito\n" +
+		"  4  processamento interrompido: planilhas de entrada, arquivo config, colunas faltando (-validate) ou diretorio de saida\n" +
+		"  5  falha inesperada",
+		"Exit codes:\n" +
+			"  0  success\n" +
+			"  1  invalid command line\n" +
+			"  2  required options missing in the config\n" +
+			"  3  processing finished with errors: rows not generated, _ERRO files or batch cancelled in strict mode\n" +
+			"  4  processing aborted: input spreadsheets, config file, missing columns (-validate) or output directory\n" +
+			"  5  unexpected failure"},
+	msgFlagMediaRoot: {"Diretorio dos arquivos de midia (videos e imagens), usado pelas funcoes media_*", "Directory of the media files (videos and images), used by the media_* functions"},
+
+	msgXlsRequired:      {"arquivo XLS deve ser especificado na linha de comando", "XLS file must be given in the command line"},
+	msgConfigRequired:   {"arquivo JSON de configuracao deve ser especificado na linha de comando", "JSON config file must be given in the command line"},
+	msgInvalidOutType:   {"tipo de arquivo de saida invalido: outType = [%s]", "invalid output file type: outType = [%s]"},
+	msgInvalidOutDir:    {"diretorio [%s] nao e' valido", "directory [%s] is not valid"},
+	msgXlsCatRequired:   {"arquivo XLS de categorias deve ser especificado na linha de comando", "categories XLS file must be given in the command line"},
+	msgInvalidLang:      {"idioma invalido: [%s], use %v", "invalid language: [%s], use %v"},
+	msgOpenSheet:        {"erro ao abrir planilha [%s]: %v", "error opening spreadsheet [%s]: %v"},
+	msgSheetNotFound:    {"aba nao existente na planilha: [%s]", "sheet does not exist in the spreadsheet: [%s]"},
+	msgDuplicateHeader:  {"header da planilha duplicado: [%s]", "duplicated spreadsheet header: [%s]"},
+	msgMissingColumn:    {"coluna [%s] usada pelo config nao existe na aba '%s'", "column [%s] used by the config does not exist in sheet '%s'"},
+	msgMissingColumns:   {"%d coluna(s) faltando na aba '%s': %v", "%d column(s) missing in sheet '%s': %v"},
+	msgNoSeriesData:     {"nao ha' dados na aba 'series'", "there is no data in sheet 'series'"},
+	msgSeriesColumn:     {"campo '%s' nao encontrado na planilha de series", "field '%s' not found in the series spreadsheet"},
+	msgSeriesSheetError: {"erro na linha %d, coluna [%s]: %s", "error in line %d, column [%s]: %s"},
+	msgSeriesNoPtName:   {"serie '%s' nao tem nome em portugues", "series '%s' has no portuguese name"},
+
+	msgCategSeasonOption:   {"categ_season nao encontrada em options no config", "categ_season not found in the config options"},
+	msgFilenameFieldOption: {"ERRO ao procurar filename_field nas options [%#v]", "ERROR looking for filename_field in the options [%#v]"},
+	msgNameFieldOption:     {"ERRO ao procurar name_field nas options [%#v]", "ERROR looking for name_field in the options [%#v]"},
+	msgXlsOutputFilename:   {"elemento 'filename' nao existe em xls_output no arquivo json", "element 'filename' does not exist in xls_output in the json file"},
+	msgXlsOutputColumns:    {"elemento 'columns' nao existe em xls_output no arquivo json", "element 'columns' does not exist in xls_output in the json file"},
+	msgXlsOutputSheet:      {"elemento 'sheet' nao existe em xls_output no arquivo json", "element 'sheet' does not exist in xls_output in the json file"},
+	msgDoctypeSystem:       {"acrescente a opcao 'doctype_system' no arquivo de config", "add the option 'doctype_system' to the config file"},
+	msgOptionNotString:     {"opcao tem que ser string, chave: [%s]", "option must be a string, key: [%s]"},
+	msgElementNoFunction:   {"elemento [%s] nao tem 'function'", "element [%s] has no 'function'"},
+	msgElementWithoutFunc:  {"elemento sem atributo 'function': [%s]", "element without attribute 'function': [%s]"},
+	msgFunctionUnspecified: {"'function' nao especificada", "'function' not specified"},
+	msgFunctionNotDefined:  {"funcao nao definida: [%s]", "function not defined: [%s]"},
+	msgFunctionUndefined:   {"funcao indefinida: [%s]", "undefined function: [%s]"},
+	msgSeasonIdOption:      {"opcao 'season_id_field' nao encontrada", "option 'season_id_field' not found"},
+	msgSeriesTitleOption:   {"opcao 'series_title_field' nao encontrada", "option 'series_title_field' not found"},
+	msgSeasonNumOption:     {"opcao 'season_num_field' nao encontrada", "option 'season_num_field' not found"},
+	msgInvalidFunction2:    {"funcao2 '%s' invalida na linha", "invalid funcao2 '%s' in the line"},
+	msgFixedNoValue:        {"funcao fixed precisa de elemento 'value' na linha %v", "function fixed needs element 'value' in line %v"},
+	msgAttrmapNoAttrs:      {"atributo 'attrs' nao encontrado em funcao attrmap", "attribute 'attrs' not found in function attrmap"},
+	msgAttrmapNoName:       {"atributo 'Name' nao encontrado em funcao attrmap", "attribute 'Name' not found in function attrmap"},
+	msgConvertNoFrom:       {"elemento 'from' faltando com function 'convert': [%v]", "element 'from' missing with function 'convert': [%v]"},
+	msgConvertNoTo:         {"elemento 'to' faltando com function 'convert': [%v]", "element 'to' missing with function 'convert': [%v]"},
+	msgConvertSizes:        {"funcao 'convert' tem que ter parametros 'from' e 'to' com mesmo numero de elementos", "function 'convert' must have parameters 'from' and 'to' with the same number of elements"},
+	msgConditionNoFunction: {"condicao sem elemento 'function' na linha %v", "condition without element 'function' in line %v"},
+	msgConditionRecursive:  {"condicao recursiva (elemento 'filter' + 'function = filter') na linha %v", "recursive condition (element 'filter' + 'function = filter') in line %v"},
+	msgFieldDirMissing:     {"campo fieldDir faltando: [%v]", "field fieldDir missing: [%v]"},
+	msgSeasonFieldConfig:   {"config para campo 'season_field' nao encontrado: [%v]", "config for field 'season_field' not found: [%v]"},
+	msgEpisodeFieldConfig:  {"config para campo 'episode_field' nao encontrado: [%v]", "config for field 'episode_field' not found: [%v]"},
+	msgSeriesIdFieldConfig: {"config para campo 'series_id_field' nao encontrado: [%v]", "config for field 'series_id_field' not found: [%v]"},
+	msgVarNotFound:         {"campo 'var' nao encontrado: [%v]", "field 'var' not found: [%v]"},
+	msgOptionElement:       {"elemento [%s] inexistente na option [%v]", "element [%s] does not exist in option [%v]"},
+	msgOptionsElement:      {"elemento [%s] inexistente nas options [%v], [%v]", "element [%s] does not exist in options [%v], [%v]"},
+	msgJSONKeyNotFound:     {"chave [%v] nao encontrada no elemento json [%v]", "key [%v] not found in json element [%v]"},
+	msgAttributeError:      {"erro no atributo %s, value [%s]", "error in attribute %s, value [%s]"},
+	msgOutputEncoding:      {"opcao 'output_encoding' invalida: [%s] (ISO-8859-1, UTF-8 ou UTF-16)", "invalid option 'output_encoding': [%s] (ISO-8859-1, UTF-8 or UTF-16)"},
+	msgMediaRootMissing:    {"diretorio de midia nao informado: use -mediaroot ou a opcao 'media_root'", "media directory not given: use -mediaroot or the option 'media_root'"},
+	msgFileFieldMissing:    {"elemento [%v] sem 'file_field'", "element [%v] without 'file_field'"},
+	msgImageConstraint:     {"valor invalido em '%s': [%s]", "invalid value in '%s': [%s]"},
+	msgOptionValue:         {"valor invalido na opcao '%s': [%s]", "invalid value of the option '%s': [%s]"},
+	msgEPGSection:          {"secao [%s] nao encontrada no config", "section [%s] not found in the config"},
+	msgPackageOutType:      {"empacotamento nao disponivel para o tipo de saida [%s]", "packaging not available for the output type [%s]"},
+	msgCategTree:           {"arvore de categorias %d invalida: [%v]", "invalid category tree %d: [%v]"},
+	msgCategLevelName:      {"arvore de categorias '%s', nivel %d sem 'name'", "category tree '%s', level %d without 'name'"},
+	msgCategLevelValue:     {"arvore de categorias '%s', nivel %d: valor invalido [%v] de '%s'", "category tree '%s', level %d: invalid value [%v] of '%s'"},
+
+	msgFieldError:             {"erro no campo '%s': [%s] na linha %d", "error in field '%s': [%s] in line %d"},
+	msgElementNotInLine:       {"elemento '%s' inexistente na linha %d", "element '%s' does not exist in line %d"},
+	msgElementNotInLineV:      {"elemento '%s' inexistente na linha %v", "element '%s' does not exist in line %v"},
+	msgElementNotInLineB:      {"elemento [%s] inexistente na linha %v", "element [%s] does not exist in line %v"},
+	msgValidationFailed:       {"falha na validacao do elemento '%s': [%v], valores possiveis: %v na linha %d", "validation of element '%s' failed: [%v], possible values: %v in line %d"},
+	msgMaxLengthNotNumeric:    {"valor nao numerico em maxlenght: [%v]", "non numeric value in maxlenght: [%v]"},
+	msgOriginalTitleMissing:   {"titulo original nao informado: [%v]", "original title not informed: [%v]"},
+	msgSeasonMissing:          {"temporada nao informada: [%s]", "season not informed: [%s]"},
+	msgEpisodeMissing:         {"numero do episodio nao informado: [%s]", "episode number not informed: [%s]"},
+	msgIdMissing:              {"id nao informado: [%v] na linha %d", "id not informed: [%v] in line %d"},
+	msgUnexpectedError:        {"erro inesperado na linha %d", "unexpected error in line %d"},
+	msgAssetPrefixMissing:     {"field prefixo do Asset ID nao encontrado (prefix): [%v]", "Asset ID prefix field not found (prefix): [%v]"},
+	msgProviderMissing:        {"provider assetid nao encontrado (provider): [%v] na linha %d", "provider assetid not found (provider): [%v] in line %d"},
+	msgSuffixNumberMissing:    {"numero do sufixo do assetid (suffix_number) nao encontrado: [%v]", "assetid suffix number (suffix_number) not found: [%v]"},
+	msgTimestampMissing:       {"timestamp nao encontrada (timestamp): [%v]", "timestamp not found (timestamp): [%v]"},
+	msgFileNumberMissing:      {"numero do arquivo nao encontrado (file_number): [%v] na linha %d", "file number not found (file_number): [%v] in line %d"},
+	msgEpisodeSeasonMissing:   {"temporada do episode_id nao encontrada (%v): [%v]", "season of the episode_id not found (%v): [%v]"},
+	msgEpisodeIdMissing:       {"valor do episode_id nao encontrado (%v): [%v] na linha %d", "value of the episode_id not found (%v): [%v] in line %d"},
+	msgInvalidSeriesData:      {"dados da serie invalidos: [%v]", "invalid series data: [%v]"},
+	msgSeriesNotFound:         {"serie nao encontrada, adicione na aba series: [%s], temporada [%s]", "series not found, add it to sheet series: [%s], season [%s]"},
+	msgSeasonFieldMissing:     {"campo 'Temporada' nao encontrado: [%s]", "field 'Temporada' not found: [%s]"},
+	msgDateFormat:             {"erro no formato da data: [%v] na linha %d", "error in the date format: [%v] in line %d"},
+	msgConditionElement:       {"elemento '%s' inexistente na condicao [%v]", "element '%s' does not exist in condition [%v]"},
+	msgInvalidDate:            {"data invalida: [%s]", "invalid date: [%s]"},
+	msgExpressionElement:      {"elemento '%s' inexistente na expressao [%v]", "element '%s' does not exist in expression [%v]"},
+	msgInvalidExpressionValue: {"expressao invalida (%v): [%v]", "invalid expression (%v): [%v]"},
+	msgExpressionError:        {"erro na expressao [%s] com parametros [%#v], [%v]", "error in expression [%s] with parameters [%#v], [%v]"},
+	msgExpressionEvalFailed:   {"falha ao avaliar expressao [%s]: [%s] na linha %v", "failed to evaluate expression [%s]: [%s] in line %v"},
+	msgConvertValueNotFound:   {"valor [%s] nao consta da string 'from' no elemento 'convert'", "value [%s] is not in the string 'from' of element 'convert'"},
+	msgInvalidExpression:      {"expressao invalida (%v)", "invalid expression (%v)"},
+	msgInvalidExpressionArgs:  {"expressao invalida (%v), parametros (%v)", "invalid expression (%v), parameters (%v)"},
+	msgUnknownTechnology:      {"tecnologia indeterminada para a extensao: [%s] na linha %d", "unknown technology for the extension: [%s] in line %d"},
+	msgEmptyValue:             {"valor vazio", "empty value"},
+	msgInvalidBoolean:         {"valor booleano deve ser 'true' ou 'false'", "boolean value must be 'true' or 'false'"},
+	msgInvalidInsert:          {"ERRO: InsertElement(interface{}): %#v\n", "ERROR: InsertElement(interface{}): %#v\n"},
+	msgSeriesNotInSheet:       {"serie [%s] nao existente na aba 'series'", "series [%s] does not exist in sheet 'series'"},
+	msgInvalidSeriesValue:     {"erro ao ler serie, valor invalido [%s]", "error reading series, invalid value [%s]"},
+	msgLanguageField:          {"campo de linguagem tem %d elementos, deveria ter 2: [%s]", "language field has %d elements, should have 2: [%s]"},
+	msgSeriesSeasonNotFound:   {"serie com nome [%s] e temporada [%s] nao encontrada. Adicionar na aba 'series'", "series with name [%s] and season [%s] not found. Add it to sheet 'series'"},
+	msgCategNotFound:          {"categoria com nome [%s] nao encontrada. Adicionar na aba 'categs'", "category with name [%s] not found. Add it to sheet 'categs'"},
+	msgEmptyMoney:             {"valor monetario vazio: [%s]", "empty money value: [%s]"},
+	msgInvalidMoney:           {"valor monetario invalido: [%s]", "invalid money value: [%s]"},
+	msgMoneyFormat:            {"formato monetario invalido: [%s]", "invalid money format: [%s]"},
+	msgSuffixOverflow:         {"sufixo [%s] nao pode ser aplicado porque estoura o tamanho maximo [%d] no elemento [%s]", "suffix [%s] can't be applied because it exceeds the maximum length [%d] in element [%s]"},
+	msgEmptyDuration:          {"duracao vazia", "empty duration"},
+	msgNegativeDuration:       {"duracao negativa: [%s]", "negative duration: [%s]"},
+	msgDurationFormat:         {"formato de duracao invalido: [%s]", "invalid duration format: [%s]"},
+	msgInvalidChars:           {"%d caracter(es) invalido(s) [%v] na string [%s]", "%d invalid character(s) [%v] in string [%s]"},
+	msgConversionFailed:       {"falha na conversao do campo '%s' para o tipo '%s': [%s]", "failed to convert field '%s' to type '%s': [%s]"},
+	msgMediaNotFound:          {"arquivo de midia [%s] nao encontrado: %v", "media file [%s] not found: %v"},
+	msgMediaMismatch:          {"valor informado [%s] difere do calculado [%s] para o arquivo [%s]", "given value [%s] differs from the computed one [%s] for file [%s]"},
+	msgImageFormat:            {"imagem [%s] nao e' JPEG nem PNG: %v", "image [%s] is neither JPEG nor PNG: %v"},
+	msgImageTooSmall:          {"imagem [%s] com %dx%d, minimo %dx%d", "image [%s] is %dx%d, minimum %dx%d"},
+	msgImageAspect:            {"imagem [%s] com %dx%d, proporcao esperada %s", "image [%s] is %dx%d, expected aspect ratio %s"},
+	msgEPGTime:                {"horario invalido: [%s], formato esperado [%s]", "invalid time: [%s], expected format [%s]"},
+	msgEPGEndBeforeStart:      {"fim [%s] nao e posterior ao inicio [%s]", "end [%s] is not after the start [%s]"},
+	msgDerivedSeason:          {"temporada [%s] da serie [%s] nao e' um numero", "season [%s] of the series [%s] is not a number"},
+	msgExprFunctionArgs:       {"funcao [%s] da expressao (%v) com %d argumentos, esperados %d", "function [%s] of the expression (%v) with %d arguments, expected %d"},
+
+	msgCreateFile:       {"ERRO ao criar arquivo [%#v]: %v", "ERROR creating file [%#v]: %v"},
+	msgRenameFile:       {"ERRO ao renomear arquivo [%s]: %v", "ERROR renaming file [%s]: %v"},
+	msgMoveFile:         {"ERRO ao mover arquivo [%s] para [%s]: %v", "ERROR moving file [%s] to [%s]: %v"},
+	msgSentinelValue:    {"arquivo [%s] contem valor de erro '%s'", "file [%s] contains error value '%s'"},
+	msgFileIsDir:        {"arquivo [%s] nao pode ser aberto pois e' um diretorio", "file [%s] can't be opened because it is a directory"},
+	msgFileNotWritable:  {"arquivo [%s] nao pode ser sobrescrito", "file [%s] can't be overwritten"},
+	msgCellNotFound:     {"celula [%d, %d] na planilha [%s], aba [%s] nao existe", "cell [%d, %d] in spreadsheet [%s], sheet [%s] does not exist"},
+	msgCommentCell:      {"erro ao incluir comentario na celula %s: %v", "error adding comment to cell %s: %v"},
+	msgFilenameNotFound: {"ERRO ao procurar filename na linha [%#v], field [%v]", "ERROR looking for filename in line [%#v], field [%v]"},
+	msgChecksumCache:    {"cache de checksums [%s] invalido: %v", "invalid checksum cache [%s]: %v"},
+	msgPackageFile:      {"erro ao empacotar [%s]: %v", "error packaging [%s]: %v"},
+	msgPackageChecksum:  {"checksum de [%s] no pacote [%s] diferente do original: [%s] x [%s]", "checksum of [%s] in the package [%s] differs from the original: [%s] x [%s]"},
+	msgOutputExists:     {"arquivo de saida [%s] ja existe no diretorio [%s]", "output file [%s] already exists in the directory [%s]"},
+	msgBoxCatalog:       {"catalogo anterior [%s] invalido: %v", "invalid previous catalog [%s]: %v"},
+
+	msgXMLMalformed:       {"xml mal formado: %v", "malformed xml: %v"},
+	msgRootMismatch:       {"elemento raiz '%s' diferente do DOCTYPE '%s'", "root element '%s' differs from DOCTYPE '%s'"},
+	msgTextNotAllowed:     {"texto nao permitido no elemento '%s' (linha %d)", "text not allowed in element '%s' (line %d)"},
+	msgElementUndeclared:  {"elemento '%s' nao declarado no DTD (linha %d)", "element '%s' not declared in the DTD (line %d)"},
+	msgAttrUndeclared:     {"atributo '%s' nao declarado no elemento '%s' (linha %d)", "attribute '%s' not declared in element '%s' (line %d)"},
+	msgAttrFixed:          {"atributo '%s' do elemento '%s' deve ser [%s] (linha %d)", "attribute '%s' of element '%s' must be [%s] (line %d)"},
+	msgAttrEnum:           {"valor [%s] invalido para o atributo '%s' do elemento '%s', use %v (linha %d)", "invalid value [%s] for attribute '%s' of element '%s', use %v (line %d)"},
+	msgAttrRequired:       {"atributo obrigatorio '%s' ausente no elemento '%s' (linha %d)", "required attribute '%s' missing in element '%s' (line %d)"},
+	msgElementNotEmpty:    {"elemento '%s' deve ser vazio (linha %d)", "element '%s' must be empty (line %d)"},
+	msgElementNotAllowed:  {"elemento '%s' nao permitido em '%s' (linha %d)", "element '%s' not allowed in '%s' (line %d)"},
+	msgContentInvalid:     {"conteudo do elemento '%s' (linha %d) invalido: [%s], esperado %s", "invalid content of element '%s' (line %d): [%s], expected %s"},
+	msgMinOccurs:          {"elemento '%s' deve ter no minimo %d '%s', encontrado(s) %d (linha %d)", "element '%s' must have at least %d '%s', found %d (line %d)"},
+	msgMaxOccurs:          {"elemento '%s' deve ter no maximo %d '%s', encontrado(s) %d (linha %d)", "element '%s' must have at most %d '%s', found %d (line %d)"},
+	msgMaxLength:          {"valor de '%s' excede %d caracteres (linha %d)", "value of '%s' exceeds %d characters (line %d)"},
+	msgValueInvalid:       {"valor [%s] invalido para '%s' (linha %d)", "invalid value [%s] for '%s' (line %d)"},
+	msgValueEnum:          {"valor [%s] invalido para '%s', use %v (linha %d)", "invalid value [%s] for '%s', use %v (line %d)"},
+	msgUnsupportedCharset: {"codificacao nao suportada: [%s]", "unsupported encoding: [%s]"},
+	msgADI3Root:           {"config nao gera um documento ADI 1.1, elemento raiz: [%s]", "config does not generate an ADI 1.1 document, root element: [%s]"},
+	msgADI3Write:          {"erro ao gerar ADI 3.0: %v", "error generating ADI 3.0: %v"},
+	msgMRSSWrite:          {"erro ao gerar o feed MRSS: %v", "error generating the MRSS feed: %v"},
+	msgDTDElement:         {"DTD: elemento '%s': %v", "DTD: element '%s': %v"},
+	msgDTDAttlist:         {"DTD: atributos de elemento nao declarado '%s'", "DTD: attributes of undeclared element '%s'"},
+	msgContentModel:       {"modelo de conteudo invalido [%s]", "invalid content model [%s]"},
+	msgContentModelRegexp: {"modelo de conteudo invalido [%s]: %v", "invalid content model [%s]: %v"},
+	msgSchemaJSON:         {"schema JSON invalido: %v", "invalid JSON schema: %v"},
+	msgJSONSchemaRef:      {"%s: [%s] referencia de schema invalida [%s]", "%s: [%s] invalid schema reference [%s]"},
+	msgJSONType:           {"%s: [%s] tipo invalido '%s', esperado %s", "%s: [%s] invalid type '%s', expected %s"},
+	msgJSONEnum:           {"%s: [%s] valor [%v] invalido, use %v", "%s: [%s] invalid value [%v], use %v"},
+	msgJSONPattern:        {"%s: [%s] valor [%s] nao corresponde ao formato %s", "%s: [%s] value [%s] does not match the format %s"},
+	msgJSONRequired:       {"%s: [%s] campo obrigatorio '%s' ausente", "%s: [%s] required field '%s' missing"},
+	msgCategAssetRef:      {"%s: categoria [%s]: asset [%s] nao existe em %s", "%s: category [%s]: asset [%s] does not exist in %s"},
+	msgCategSerieRef:      {"%s: categoria [%s]: serie [%s] nao existe em %s", "%s: category [%s]: series [%s] does not exist in %s"},
+	msgCategParentRef:     {"%s: categoria [%s]: parent_id [%s] nao existe em %s", "%s: category [%s]: parent_id [%s] does not exist in %s"},
+
+	msgInvalidRule:      {"regra %d invalida: [%v]", "invalid rule %d: [%v]"},
+	msgRuleSeverity:     {"regra '%s': severidade invalida [%s], use '%s' ou '%s'", "rule '%s': invalid severity [%s], use '%s' or '%s'"},
+	msgRuleNoCondition:  {"regra '%s' sem 'condition'", "rule '%s' without 'condition'"},
+	msgRuleExpression:   {"regra '%s': expressao invalida [%s]: %v", "rule '%s': invalid expression [%s]: %v"},
+	msgRuleFilterFailed: {"falha ao avaliar filtro da regra [%s]: %v", "failed to evaluate the rule filter [%s]: %v"},
+	msgRuleFailed:       {"falha ao avaliar regra [%s]: %v", "failed to evaluate rule [%s]: %v"},
+	msgRuleViolated:     {"%s", "%s"},
+	msgUniqueIndex:      {"indice de entregas [%s] invalido: %v", "invalid deliveries index [%s]: %v"},
+	msgUniqueRepeated:   {"valor [%s] de '%s' repetido nas linhas %d e %d", "value [%s] of '%s' repeated in lines %d and %d"},
+	msgUniqueDelivered:  {"valor [%s] de '%s' ja' entregue no arquivo [%s] (aba '%s', linha %d, %s)", "value [%s] of '%s' already delivered in file [%s] (sheet '%s', line %d, %s)"},
+	msgVersionRegistry:  {"registro de versoes [%s] invalido: %v", "invalid version registry [%s]: %v"},
+	msgVersionNoPrev:    {"remocao de [%s]: asset nao encontrado no registro de versoes", "deletion of [%s]: asset not found in the version registry"},
+	msgVersionNumber:    {"%s nao numerico: [%s]", "%s is not numeric: [%s]"},
+	msgVersionKey:       {"coluna [%s] com o id do asset vazia: necessaria ao registro de versoes", "column [%s] with the id of the asset is empty: required by the version registry"},
+	msgEPGOverlap:       {"canal [%s]: programa comeca antes do fim do programa da linha %d (%s)", "channel [%s]: programme starts before the end of the programme of row %d (%s)"},
+	msgEPGGap:           {"canal [%s]: intervalo de %s sem programacao apos a linha %d", "channel [%s]: gap of %s without programmes after row %d"},
+}
+
+// flagMessages holds the usage messages of the command line flags
+var flagMessages = map[string]string{
+	"xls":       msgFlagXls,
+	"config":    msgFlagConfig,
+	"outtype":   msgFlagOutType,
+	"outdir":    msgFlagOutDir,
+	"xlscat":    msgFlagXlsCat,
+	"genrecat":  msgFlagGenreCat,
+	"validate":  msgFlagValidate,
+	"strict":    msgFlagStrict,
+	"lang":      msgFlagLang,
+	"mediaroot": msgFlagMediaRoot,
+}
+
+// msg formats a message of the catalog in the selected language. Unknown codes are returned as is
+func msg(code string, args ...interface{}) string {
+	m, ok := catalog[code]
+	if !ok {
+		return code
+	}
+	format := m.pt
+	if lang == langEN {
+		format = m.en
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// setLang selects the language of the messages, translating the usage of the command line flags
+func setLang(l string) error {
+	if l != langPT && l != langEN {
+		return newError(msgInvalidLang, l, []string{langPT, langEN})
+	}
+	lang = l
+	flag.VisitAll(func(f *flag.Flag) {
+		if code, ok := flagMessages[f.Name]; ok {
+			f.Usage = msg(code)
+		}
+	})
+	return nil
+}
+
+// codedErrorT is an error of the message catalog, formatted in the selected language
+type codedErrorT struct {
+	code string
+	args []interface{}
+}
+
+// newError creates an error with a message of the catalog
+func newError(code string, args ...interface{}) error {
+	return &codedErrorT{code: code, args: args}
+}
+
+func (e *codedErrorT) Error() string {
+	return msg(e.code, e.args...)
+}
+
+// Code returns the message code of the error
+func (e *codedErrorT) Code() string {
+	return e.code
+}
+
+// errorCode returns the code of the outermost error of the catalog wrapped by err, or "" if there is none
+func errorCode(err error) string {
+	var coded interface{ Code() string }
+	if errors.As(err, &coded) {
+		return coded.Code()
+	}
+	return ""
+}
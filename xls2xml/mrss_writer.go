@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	xw "github.com/shabbyrobe/xmlwriter"
+)
+
+// Media RSS namespaces
+const (
+	mediaNS   = "http://search.yahoo.com/mrss/"
+	dctermsNS = "http://purl.org/dc/terms/"
+)
+
+// Options of the MRSS output
+const (
+	mrssFileOpt        = "mrss_file"        // name of the feed, without extension. Default: feed
+	mrssTitleOpt       = "mrss_title"       // title of the channel of the feed
+	mrssLinkOpt        = "mrss_link"        // link of the channel of the feed
+	mrssDescriptionOpt = "mrss_description" // description of the channel of the feed
+)
+
+const defaultMRSSFile = "feed"
+
+// mrssWriter writes the items of a Media RSS feed. The elements of each pack are kept in memory and the
+// feed of the batch is written by WriteConsolidated
+type mrssWriter struct {
+	run      *runT
+	fileName string
+	roots    []xw.Elem
+	stack    []*xw.Elem
+	testing  bool
+}
+
+// newMRSSWriter creates a new struct. All the packs of the directory write to the same feed
+func newMRSSWriter(run *runT, filename string) (*mrssWriter, error) {
+	feed := run.options["options"][mrssFileOpt]
+	if feed == "" {
+		feed = defaultMRSSFile
+	}
+	return &mrssWriter{run: run, fileName: path.Join(path.Dir(filename), feed)}, nil
+}
+
+// Suffix returns the output file extension
+func (wr *mrssWriter) Suffix() string {
+	return ".xml"
+}
+
+// Filename returns the feed file name
+func (wr *mrssWriter) Filename() string {
+	return wr.fileName
+}
+
+// OpenOutput prepares to write the items of a pack
+func (wr *mrssWriter) OpenOutput() error {
+	wr.roots = nil
+	wr.stack = nil
+	return nil
+}
+
+// StartElem starts an element. Prefixed names, like media:content, are kept
+func (wr *mrssWriter) StartElem(name string, _ elemType) error {
+	el := adi3Elem(name)
+	wr.stack = append(wr.stack, &el)
+	return nil
+}
+
+// EndElem closes an element, adding it to its parent
+func (wr *mrssWriter) EndElem(name string, _ elemType) error {
+	if len(wr.stack) == 0 {
+		return nil
+	}
+	el := wr.stack[len(wr.stack)-1]
+	wr.stack = wr.stack[:len(wr.stack)-1]
+	if name == "dcterms:valid" {
+		mrssPeriod(el)
+	}
+	if len(wr.stack) > 0 {
+		parent := wr.stack[len(wr.stack)-1]
+		parent.Content = append(parent.Content, *el)
+	} else {
+		wr.roots = append(wr.roots, *el)
+	}
+	return nil
+}
+
+// mrssPeriod converts the attributes start and end of dcterms:valid to a DCMI period
+func mrssPeriod(el *xw.Elem) {
+	var period []string
+	for _, a := range el.Attrs {
+		if a.Value != "" {
+			period = append(period, a.Name+"="+a.Value)
+		}
+	}
+	if len(period) == 0 {
+		return
+	}
+	el.Attrs = nil
+	el.Content = append(el.Content, xw.Text(strings.Join(append(period, "scheme=W3C-DTF"), "; ")))
+}
+
+// StartComment starts a comment section. Comments are not written in the feed: its elements are discarded
+func (wr *mrssWriter) StartComment(string) error {
+	wr.stack = append(wr.stack, &xw.Elem{})
+	return nil
+}
+
+// EndComment closes a comment section
+func (wr *mrssWriter) EndComment(string) error {
+	if len(wr.stack) > 0 {
+		wr.stack = wr.stack[:len(wr.stack)-1]
+	}
+	return nil
+}
+
+func (wr *mrssWriter) Write(value string) error {
+	if len(wr.stack) > 0 && value != "" {
+		el := wr.stack[len(wr.stack)-1]
+		el.Content = append(el.Content, xw.Text(value))
+	}
+	return nil
+}
+
+// WriteAttr adds an attribute to the current element
+func (wr *mrssWriter) WriteAttr(name string, value string, vtype string, attrType string) error {
+	val, err := xmlValue(name, value, vtype)
+	if attrType == "ott" {
+		_ = wr.Write(val)
+	} else if len(wr.stack) > 0 {
+		el := wr.stack[len(wr.stack)-1]
+		el.Attrs = append(el.Attrs, xw.Attr{Name: name, Value: val})
+	}
+	return err
+}
+
+// WriteAndClose adds the items of the pack to the feed
+func (wr *mrssWriter) WriteAndClose(filename string) error {
+	wr.run.mrssItems[filename] = append(wr.run.mrssItems[filename], wr.roots...)
+	return nil
+}
+
+// WriteConsolidated writes the feeds of the batch. The content of the feed without errors is returned
+func (wr *mrssWriter) WriteConsolidated(int) (bufFeed []byte, _ []byte, _ []byte, err error) {
+	files := make([]string, 0, len(wr.run.mrssItems))
+	for f := range wr.run.mrssItems {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		b := &bytes.Buffer{}
+		if err = writeMRSS(b, wr.run.mrssItems[f], wr.run.options); err != nil {
+			return
+		}
+		if deliverable(f) {
+			bufFeed = b.Bytes()
+		}
+		if wr.testing {
+			continue
+		}
+		log(msg(msgSaving, f))
+		if err = ioutil.WriteFile(f, b.Bytes(), 0644); err != nil {
+			err = newError(msgCreateFile, f, err)
+			return
+		}
+		wr.run.registerOutput(f)
+	}
+	return
+}
+
+// writeMRSS writes a feed with the channel given by the options
+func writeMRSS(b *bytes.Buffer, items []xw.Elem, options optionsT) error {
+	w := xw.Open(b, xw.WithIndentString("\t"))
+	ec := &xw.ErrCollector{}
+	ec.Do(w.StartDoc(xw.Doc{}))
+	ec.Do(w.StartElem(xw.Elem{Name: "rss", Attrs: []xw.Attr{
+		{Name: "version", Value: "2.0"},
+		{Prefix: "xmlns", Name: "media", Value: mediaNS},
+		{Prefix: "xmlns", Name: "dcterms", Value: dctermsNS},
+	}}))
+	ec.Do(w.StartElem(xw.Elem{Name: "channel"}))
+	for _, opt := range []string{mrssTitleOpt, mrssLinkOpt, mrssDescriptionOpt} {
+		el := xw.Elem{Name: strings.TrimPrefix(opt, "mrss_")}
+		if val := options["options"][opt]; val != "" {
+			el.Content = []xw.Writable{xw.Text(val)}
+		}
+		ec.Do(w.Write(el))
+	}
+	for _, item := range items {
+		writeADI3Elem(w, ec, item)
+	}
+	ec.Do(w.EndAllFlush())
+	if ec.Err != nil {
+		return newError(msgMRSSWrite, ec.Err)
+	}
+	return nil
+}
+
+// StartMap starts a map element
+func (wr *mrssWriter) StartMap() error {
+	return nil
+}
+
+// EndMap closes a map element
+func (wr *mrssWriter) EndMap() error {
+	return nil
+}
+
+// Testing returns true if is running in a testing environment
+func (wr *mrssWriter) Testing() bool {
+	return wr.testing
+}
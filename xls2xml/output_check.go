@@ -0,0 +1,134 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// Values written in place of a value that could not be generated
+var sentinelValues = []string{errorMessage[0].val, "##UNDEFINED##", "###"}
+
+// registerOutput records a file written by a writer
+func (r *runT) registerOutput(filename string) {
+	for _, f := range r.outputFiles {
+		if f == filename {
+			return
+		}
+	}
+	r.outputFiles = append(r.outputFiles, filename)
+}
+
+// deliverable returns true if the file is not an error file (<name>_ERRO.<ext>)
+func deliverable(filename string) bool {
+	return !strings.HasSuffix(strings.TrimSuffix(filename, path.Ext(filename)), errSuffix)
+}
+
+// errorFilename returns the name of the error version of a file
+func errorFilename(filename string) string {
+	ext := path.Ext(filename)
+	return strings.TrimSuffix(filename, ext) + errSuffix + ext
+}
+
+// readOutput returns the content of an output file. The parts of xlsx files are concatenated and
+// UTF-16 files are converted to UTF-8
+func readOutput(filename string) ([]byte, error) {
+	zf, err := zip.OpenReader(filename)
+	if err != nil {
+		// not a zip file
+		content, errR := ioutil.ReadFile(filename)
+		if errR != nil {
+			return nil, errR
+		}
+		return decodeUTF16(content), nil
+	}
+	defer zf.Close()
+	b := &bytes.Buffer{}
+	for _, f := range zf.File {
+		rc, errO := f.Open()
+		if errO != nil {
+			return nil, errO
+		}
+		_, errR := b.ReadFrom(rc)
+		rc.Close()
+		if errR != nil {
+			return nil, errR
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// findSentinel returns the first sentinel value found in the content, or ""
+func findSentinel(content []byte) string {
+	for _, s := range sentinelValues {
+		if bytes.Contains(content, []byte(s)) {
+			return s
+		}
+	}
+	return ""
+}
+
+// checkOutputs scans the deliverable files written by the run, returning an error for each one
+// containing a sentinel value. In lenient mode, these files are renamed to <name>_ERRO.<ext>
+func (r *runT) checkOutputs(strict bool) (errs []error) {
+	for _, filename := range r.outputFiles {
+		if !deliverable(filename) {
+			continue
+		}
+		content, err := readOutput(filename)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// removed by the run
+				continue
+			}
+			errs = append(errs, err)
+			continue
+		}
+		sentinel := findSentinel(content)
+		if sentinel == "" {
+			continue
+		}
+		errs = append(errs, newError(msgSentinelValue, filename, sentinel))
+		if strict {
+			continue
+		}
+		if err = r.renameOutput(filename, errorFilename(filename)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return
+}
+
+// renameOutput renames a file written by the run
+func (r *runT) renameOutput(filename string, newName string) error {
+	if err := os.Rename(filename, newName); err != nil {
+		return newError(msgRenameFile, filename, err)
+	}
+	for i, f := range r.outputFiles {
+		if f == filename {
+			r.outputFiles[i] = newName
+		}
+	}
+	return nil
+}
+
+// commitOutputs moves the files written in the staging directory to the output directory
+func (r *runT) commitOutputs(stagingDir string, outDir string) error {
+	for i, filename := range r.outputFiles {
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(filename, stagingDir), "/")
+		target := path.Join(outDir, rel)
+		// a previous error version of the file is obsolete
+		_ = os.Remove(errorFilename(target))
+		if err := os.Rename(filename, target); err != nil {
+			return newError(msgMoveFile, filename, target, err)
+		}
+		r.outputFiles[i] = target
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options of the output file names
+const (
+	outputFilenameOpt  = "output_filename"  // template of the file names, like "{field:Prefixo}{date:20060102}{counter:8}"
+	outputCounterOpt   = "output_counter"   // first value of {counter}. Default: 0
+	outputCollisionOpt = "output_collision" // error (default) or suffix: packs with the same file name
+	outputExistingOpt  = "output_existing"  // overwrite (default) or collision: files found in the output directory
+)
+
+// Placeholders of the file name template: {field:<column>[:<length>]}, {option:<name>}, {date:<layout>},
+// {counter[:<digits>]}
+var templateRegexp = regexp.MustCompile(`\{([a-z_]+)(?::([^}]*))?\}`)
+
+// fileNamerT gives the names of the output files of a batch
+type fileNamerT struct {
+	options  optionsT
+	template string
+	counter  int
+	suffix   bool   // collisions get a numeric suffix instead of failing
+	dir      string // directory whose files are collisions, "" if they are overwritten
+	used     map[string]bool
+}
+
+// newFileNamer reads the options of the output file names. dir is the directory where the files will be
+// delivered
+func newFileNamer(opts optionsT, dir string) (*fileNamerT, error) {
+	n := &fileNamerT{options: opts, template: opts["options"][outputFilenameOpt], used: make(map[string]bool)}
+	for _, m := range templateRegexp.FindAllStringSubmatch(n.template, -1) {
+		if !contains([]string{"field", "option", "date", "counter"}, m[1]) {
+			return nil, newError(msgOptionValue, outputFilenameOpt, m[0])
+		}
+		if _, err := strconv.Atoi(m[2]); m[1] == "counter" && m[2] != "" && err != nil {
+			return nil, newError(msgOptionValue, outputFilenameOpt, m[0])
+		}
+	}
+	if val := opts["options"][outputCounterOpt]; val != "" {
+		c, err := strconv.Atoi(val)
+		if err != nil || c < 0 {
+			return nil, newError(msgOptionValue, outputCounterOpt, val)
+		}
+		n.counter = c
+	}
+	switch val := opts["options"][outputCollisionOpt]; val {
+	case "", "error":
+	case "suffix":
+		n.suffix = true
+	default:
+		return nil, newError(msgOptionValue, outputCollisionOpt, val)
+	}
+	switch val := opts["options"][outputExistingOpt]; val {
+	case "", "overwrite":
+	case "collision":
+		n.dir = dir
+		if n.dir == "" {
+			n.dir = "."
+		}
+	default:
+		return nil, newError(msgOptionValue, outputExistingOpt, val)
+	}
+	return n, nil
+}
+
+// name returns the name, without extension, of the output file of a pack. Without template, the name is
+// the value of the name field
+func (n *fileNamerT) name(pack []lineT, nameValue string) (string, error) {
+	if n.template == "" {
+		name, err := replaceAllNonAlpha(path.Base(nameValue))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(name, path.Ext(name)), nil
+	}
+	name := templateRegexp.ReplaceAllStringFunc(n.template, func(s string) string {
+		m := templateRegexp.FindStringSubmatch(s)
+		return n.placeholder(m[1], m[2], &pack[0])
+	})
+	n.counter++
+	noacc, err := removeAccents(name)
+	if err != nil {
+		return "", err
+	}
+	return replaceAllNonAlpha(noacc)
+}
+
+// placeholder returns the value of a placeholder of the template
+func (n *fileNamerT) placeholder(kind string, arg string, line *lineT) string {
+	switch kind {
+	case "field":
+		return fieldPlaceholder(arg, line)
+	case "option":
+		return n.options["options"][arg]
+	case "date":
+		if arg == "" {
+			arg = "20060102"
+		}
+		return runTime(n.options).Format(arg)
+	default:
+		// validated by newFileNamer
+		digits, _ := strconv.Atoi(arg)
+		return fmt.Sprintf("%0*d", digits, n.counter)
+	}
+}
+
+// templateField returns the column and the length of a field placeholder (<column>[:<length>])
+func templateField(arg string) (string, int) {
+	if i := strings.LastIndex(arg, ":"); i > 0 {
+		if l, err := strconv.Atoi(arg[i+1:]); err == nil {
+			return arg[:i], l
+		}
+	}
+	return arg, 0
+}
+
+// fieldPlaceholder returns the value of a field placeholder (<column>[:<length>]) for a line
+func fieldPlaceholder(arg string, line *lineT) string {
+	col, length := templateField(arg)
+	val := []rune(strings.TrimSpace(line.fields[strings.ToLower(col)]))
+	if length > 0 && len(val) > length {
+		val = val[:length]
+	}
+	return string(val)
+}
+
+// runTime returns the time of the run, given by the option 'timestamp'
+func runTime(options optionsT) time.Time {
+	if t, err := time.ParseInLocation("060102150405", options["options"]["timestamp"], time.Local); err == nil {
+		return t
+	}
+	return time.Now()
+}
+
+// resolve checks the name of the output file of a pack against the names of the batch and the files of the
+// output directory. Names used by the batch are checked by the uniqueness of the file names, unless they
+// get a suffix
+func (n *fileNamerT) resolve(name string, line *lineT) (string, error) {
+	newName := name
+	for i := 2; n.taken(newName); i++ {
+		if !n.suffix {
+			if n.used[strings.ToLower(newName)] {
+				return name, nil
+			}
+			return name, uniqueConflict(line, "", newError(msgOutputExists, name, n.dir))
+		}
+		newName = fmt.Sprintf("%s_%d", name, i)
+	}
+	if newName != name {
+		log(msg(msgOutputRenamed, name, newName))
+	}
+	n.used[strings.ToLower(newName)] = true
+	return newName, nil
+}
+
+// taken returns true if the name was used by the batch or by a file of the output directory
+func (n *fileNamerT) taken(name string) bool {
+	if n.used[strings.ToLower(name)] {
+		return true
+	}
+	if n.dir == "" {
+		return false
+	}
+	files, _ := filepath.Glob(filepath.Join(n.dir, name+".*"))
+	return len(files) > 0
+}
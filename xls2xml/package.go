@@ -0,0 +1,414 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"crypto/md5"
+	js "encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Options of the delivery packages
+const (
+	packageFormatOpt = "package_format" // dir, zip or tar. Default: no packages
+	packageModeOpt   = "package_mode"   // asset (one archive per asset, default) or batch (one archive)
+	packageNameOpt   = "package_name"   // name of the archive of the batch. Default: pacote_<date>
+)
+
+const packageManifest = "manifest.json"
+
+// Functions and elements giving the location of the media files of an asset
+var (
+	locationFunctions = []string{"field_suffix", "location_series", "location_series_box"}
+	mediaElements     = []string{"Value", "location", "url"}
+)
+
+// packageT is a delivered asset: its output file and its media files, relative to the media root
+type packageT struct {
+	name  string
+	file  string
+	media []string
+}
+
+// manifestFileT is a file of a package
+type manifestFileT struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	MD5  string `json:"md5"`
+}
+
+// manifestT describes the content of a package
+type manifestT struct {
+	Asset   string          `json:"asset"`
+	Created string          `json:"created"`
+	Files   []manifestFileT `json:"files"`
+}
+
+// packagerT builds the delivery packages of the batch. Each asset gets a directory with its output file,
+// its media files and a manifest, archived by asset or by batch
+type packagerT struct {
+	run       *runT
+	format    string
+	mode      string
+	name      string
+	mediaRoot string
+	assets    []packageT
+	media     []string // media of the pack being processed
+}
+
+// newPackager reads the package options of the run. Returns nil if the option 'package_format' is not given
+func newPackager(run *runT, outType string) (*packagerT, error) {
+	opts := run.options
+	p := &packagerT{
+		run:       run,
+		format:    opts["options"][packageFormatOpt],
+		mode:      opts["options"][packageModeOpt],
+		name:      opts["options"][packageNameOpt],
+		mediaRoot: opts["options"][mediaRootOpt],
+	}
+	if p.format == "" {
+		return nil, nil
+	}
+	if !contains([]string{"dir", "zip", "tar"}, p.format) {
+		return nil, newError(msgOptionValue, packageFormatOpt, p.format)
+	}
+	if p.mode == "" {
+		p.mode = "asset"
+	}
+	if !contains([]string{"asset", "batch"}, p.mode) {
+		return nil, newError(msgOptionValue, packageModeOpt, p.mode)
+	}
+	if p.name == "" {
+		p.name = "pacote_" + time.Now().Format("20060102_150405")
+	}
+	if outType != "xml" && outType != "adi3" {
+		return nil, newError(msgPackageOutType, outType)
+	}
+	if p.mediaRoot == "" {
+		return nil, newError(msgMediaRootMissing)
+	}
+	return p, nil
+}
+
+// startPack forgets the media of the previous pack
+func (p *packagerT) startPack() {
+	p.media = nil
+}
+
+// addMedia records the media files given by a location function
+func (p *packagerT) addMedia(function string, name string, vals []resultsT) {
+	if !contains(locationFunctions, function) || !contains(mediaElements, name) {
+		return
+	}
+	for _, v := range vals {
+		if v.val != "" && v.val != errorMessage[0].val && !contains(p.media, v.val) {
+			p.media = append(p.media, v.val)
+		}
+	}
+}
+
+// addPack records a pack written without errors
+func (p *packagerT) addPack(name string, file string) {
+	p.assets = append(p.assets, packageT{name: name, file: file, media: p.media})
+	p.media = nil
+}
+
+// build writes the packages in the output directory. The assets whose output file was not delivered
+// are not packaged
+func (p *packagerT) build(outDir string) (errs []error) {
+	var dirs []string
+	for _, a := range p.assets {
+		if _, err := os.Stat(path.Join(outDir, a.file)); os.IsNotExist(err) {
+			continue
+		}
+		log(msg(msgPackaging, a.name))
+		dir := path.Join(outDir, a.name)
+		if err := p.buildDir(dir, outDir, a); err != nil {
+			// no incomplete package
+			_ = os.RemoveAll(dir)
+			errs = append(errs, err)
+			continue
+		}
+		if p.mode == "asset" && p.format != "dir" {
+			if err := p.archive(path.Join(outDir, a.name+"."+p.format), outDir, []string{a.name}); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		dirs = append(dirs, a.name)
+	}
+	if p.mode == "batch" && p.format != "dir" && len(dirs) > 0 {
+		log(msg(msgPackaging, p.name))
+		if err := p.archive(path.Join(outDir, p.name+"."+p.format), outDir, dirs); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return
+}
+
+// buildDir copies the output file and the media files of the asset to its directory, writing the
+// manifest
+func (p *packagerT) buildDir(dir string, outDir string, a packageT) error {
+	// files of a previous package are obsolete
+	if err := os.RemoveAll(dir); err != nil {
+		return newError(msgPackageFile, dir, err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return newError(msgPackageFile, dir, err)
+	}
+	manifest := manifestT{Asset: a.name, Created: time.Now().Format(time.RFC3339)}
+	f, err := copyPackageFile(path.Join(outDir, a.file), dir, a.file, "")
+	if err != nil {
+		return err
+	}
+	manifest.Files = append(manifest.Files, f)
+	cache, err := p.run.checksumCache()
+	if err != nil {
+		return err
+	}
+	for _, m := range a.media {
+		rel := strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(m)), "/")
+		src := filepath.Join(p.mediaRoot, filepath.FromSlash(rel))
+		st, errS := os.Stat(src)
+		if errS != nil {
+			return newError(msgMediaNotFound, src, errS)
+		}
+		sum, errM := cache.md5(src, st)
+		if errM != nil {
+			return errM
+		}
+		if f, err = copyPackageFile(src, dir, rel, sum); err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, f)
+	}
+	buf, err := js.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return err
+	}
+	filename := path.Join(dir, packageManifest)
+	if err = ioutil.WriteFile(filename, buf, 0644); err != nil {
+		return newError(msgCreateFile, filename, err)
+	}
+	p.run.registerOutput(filename)
+	return nil
+}
+
+// copyPackageFile copies a file to the package directory, checking its MD5 against the given one.
+// Media files are linked when possible
+func copyPackageFile(src string, dir string, rel string, sum string) (manifestFileT, error) {
+	target := path.Join(dir, rel)
+	if err := os.MkdirAll(path.Dir(target), 0755); err != nil {
+		return manifestFileT{}, newError(msgPackageFile, target, err)
+	}
+	st, err := os.Stat(src)
+	if err != nil {
+		return manifestFileT{}, newError(msgPackageFile, src, err)
+	}
+	if sum != "" && os.Link(src, target) == nil {
+		// hard link: the content is the original file
+		return manifestFileT{Name: rel, Size: st.Size(), MD5: sum}, nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return manifestFileT{}, newError(msgPackageFile, src, err)
+	}
+	defer in.Close()
+	out, err := os.Create(target)
+	if err != nil {
+		return manifestFileT{}, newError(msgPackageFile, target, err)
+	}
+	h := md5.New()
+	size, err := io.Copy(io.MultiWriter(out, h), in)
+	if errC := out.Close(); err == nil {
+		err = errC
+	}
+	if err != nil {
+		return manifestFileT{}, newError(msgPackageFile, target, err)
+	}
+	copied := md5Hex(h.Sum(nil))
+	if sum != "" && copied != sum {
+		return manifestFileT{}, newError(msgPackageChecksum, rel, dir, copied, sum)
+	}
+	return manifestFileT{Name: rel, Size: size, MD5: copied}, nil
+}
+
+// md5Hex returns a MD5 in uppercase hex, like the checksum cache
+func md5Hex(sum []byte) string {
+	return strings.ToUpper(fmt.Sprintf("%x", sum))
+}
+
+// archive writes the directories of the assets in a zip or tar file, checking the archive against the
+// manifests
+func (p *packagerT) archive(filename string, outDir string, dirs []string) error {
+	// entry name -> MD5 expected
+	expected := make(map[string]string)
+	var files []string
+	for _, d := range dirs {
+		buf, err := ioutil.ReadFile(path.Join(outDir, d, packageManifest))
+		if err != nil {
+			return newError(msgPackageFile, filename, err)
+		}
+		var manifest manifestT
+		if err = js.Unmarshal(buf, &manifest); err != nil {
+			return newError(msgPackageFile, filename, err)
+		}
+		for _, f := range manifest.Files {
+			expected[d+"/"+f.Name] = f.MD5
+		}
+		sum := md5.Sum(buf)
+		expected[d+"/"+packageManifest] = md5Hex(sum[:])
+	}
+	for name := range expected {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	prefix := ""
+	if p.mode == "asset" {
+		// the archive of an asset has its files at the root
+		prefix = dirs[0] + "/"
+	}
+	var err error
+	if p.format == "zip" {
+		err = writeZip(filename, outDir, files, prefix)
+	} else {
+		err = writeTar(filename, outDir, files, prefix)
+	}
+	if err != nil {
+		return err
+	}
+	p.run.registerOutput(filename)
+	sums, err := archiveMD5s(filename, p.format)
+	if err != nil {
+		return newError(msgPackageFile, filename, err)
+	}
+	for _, name := range files {
+		entry := strings.TrimPrefix(name, prefix)
+		if sums[entry] != expected[name] {
+			errC := newError(msgPackageChecksum, entry, filename, sums[entry], expected[name])
+			if errR := p.run.renameOutput(filename, errorFilename(filename)); errR != nil {
+				return errR
+			}
+			return errC
+		}
+	}
+	return nil
+}
+
+// writeZip writes a zip file. Media files, already compressed, are stored
+func writeZip(filename string, outDir string, files []string, prefix string) error {
+	out, err := os.Create(filename)
+	if err != nil {
+		return newError(msgCreateFile, filename, err)
+	}
+	defer out.Close()
+	zw := zip.NewWriter(out)
+	for _, name := range files {
+		hdr := &zip.FileHeader{Name: strings.TrimPrefix(name, prefix), Method: zip.Store, Modified: time.Now()}
+		if ext := path.Ext(name); ext == ".xml" || ext == ".json" {
+			hdr.Method = zip.Deflate
+		}
+		w, errH := zw.CreateHeader(hdr)
+		if errH != nil {
+			return newError(msgPackageFile, filename, errH)
+		}
+		if err = copyToArchive(w, path.Join(outDir, name)); err != nil {
+			return newError(msgPackageFile, filename, err)
+		}
+	}
+	if err = zw.Close(); err != nil {
+		return newError(msgPackageFile, filename, err)
+	}
+	return nil
+}
+
+// writeTar writes a tar file
+func writeTar(filename string, outDir string, files []string, prefix string) error {
+	out, err := os.Create(filename)
+	if err != nil {
+		return newError(msgCreateFile, filename, err)
+	}
+	defer out.Close()
+	tw := tar.NewWriter(out)
+	for _, name := range files {
+		st, errS := os.Stat(path.Join(outDir, name))
+		if errS != nil {
+			return newError(msgPackageFile, filename, errS)
+		}
+		hdr := &tar.Header{Name: strings.TrimPrefix(name, prefix), Mode: 0644, Size: st.Size(), ModTime: st.ModTime()}
+		if err = tw.WriteHeader(hdr); err != nil {
+			return newError(msgPackageFile, filename, err)
+		}
+		if err = copyToArchive(tw, path.Join(outDir, name)); err != nil {
+			return newError(msgPackageFile, filename, err)
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return newError(msgPackageFile, filename, err)
+	}
+	return nil
+}
+
+// copyToArchive writes the content of a file in an archive entry
+func copyToArchive(w io.Writer, filename string) error {
+	in, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	_, err = io.Copy(w, in)
+	return err
+}
+
+// archiveMD5s reads an archive, returning the MD5 of each entry
+func archiveMD5s(filename string, format string) (map[string]string, error) {
+	sums := make(map[string]string)
+	if format == "zip" {
+		zr, err := zip.OpenReader(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			rc, errO := f.Open()
+			if errO != nil {
+				return nil, errO
+			}
+			h := md5.New()
+			_, errR := io.Copy(h, rc)
+			rc.Close()
+			if errR != nil {
+				return nil, errR
+			}
+			sums[f.Name] = md5Hex(h.Sum(nil))
+		}
+		return sums, nil
+	}
+	in, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	tr := tar.NewReader(in)
+	for {
+		hdr, errN := tr.Next()
+		if errN == io.EOF {
+			return sums, nil
+		}
+		if errN != nil {
+			return nil, errN
+		}
+		h := md5.New()
+		if _, err = io.Copy(h, tr); err != nil {
+			return nil, err
+		}
+		sums[hdr.Name] = md5Hex(h.Sum(nil))
+	}
+}
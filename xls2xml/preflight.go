@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Knetic/govaluate"
+)
+
+// Config keys whose value is the name of a spreadsheet column
+var columnKeys = []string{"field", "field1", "field2", "fieldDir", "prefix", "field_prefix", "attr_list", "file_field",
+	"series_field", "season_field"}
+
+// Config keys whose value is an expression over the spreadsheet columns
+var expressionKeys = []string{"filter", "condition", "expression"}
+
+// Options whose value is the name of a column of the main sheet
+var columnOptions = []string{"filename_field", "name_field", "id_field", "season_field", "episode_field",
+	"categ_field1", "categ_field2", "categ_field3", seriesTitleColumnOpt, verbFieldOpt, epgChannelFieldOpt, epgStartFieldOpt, epgEndFieldOpt}
+
+// Columns read directly by some functions, without a reference in the config
+var functionColumns = map[string][]string{
+	"location_series":     {"título original", "temporada", "número do episódio"},
+	"location_series_box": {"título original", "temporada", "número do episódio"},
+	"series_id":           {"temporada"},
+	"season_id":           {"temporada"},
+}
+
+// columnCheckT is the result of the comparison between the config and the sheet headers
+type columnCheckT struct {
+	missing []string // referenced by the config, absent from the sheet
+	unused  []string // present in the sheet, never referenced by the config
+}
+
+// configColumns walks the config and returns every column it references, lowercase
+func configColumns(json map[string]interface{}, opts optionsT) []string {
+	refs := make(map[string]string)
+	optsFound := make(map[string]string)
+	for k, v := range opts["options"] {
+		optsFound[k] = v
+	}
+	walkConfig(json, refs, optsFound)
+	for _, opt := range columnOptions {
+		if val := optsFound[opt]; val != "" {
+			addColumnRef(refs, val)
+		}
+	}
+	for _, col := range strings.Split(optsFound["unique_fields"], ",") {
+		if col = strings.TrimSpace(col); col != "" {
+			addColumnRef(refs, col)
+		}
+	}
+	templates := []string{optsFound[outputFilenameOpt]}
+	trees, _ := readCategTrees(json)
+	for _, tree := range trees {
+		for _, level := range tree.levels {
+			templates = append(templates, level.name, level.id)
+		}
+	}
+	for _, tmpl := range templates {
+		for _, m := range templateRegexp.FindAllStringSubmatch(tmpl, -1) {
+			if m[1] == "field" {
+				col, _ := templateField(m[2])
+				addColumnRef(refs, col)
+			}
+		}
+	}
+	result := make([]string, 0, len(refs))
+	for _, v := range refs {
+		result = append(result, v)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// walkConfig collects the column references of a config element and of its children
+func walkConfig(el interface{}, refs map[string]string, optsFound map[string]string) {
+	switch e := el.(type) {
+	case map[string]interface{}:
+		function, _ := e["function"].(string)
+		for _, key := range columnKeys {
+			if key == "field" && function == "option" {
+				// 'field' is the name of an option, not of a column
+				continue
+			}
+			if val, ok := e[key].(string); ok && val != "" {
+				addColumnRef(refs, val)
+			}
+		}
+		for _, key := range expressionKeys {
+			if val, ok := e[key].(string); ok && val != "" {
+				for _, v := range expressionVars(val) {
+					// expression variables have "_" instead of spaces: keep the column name if already known
+					if _, ok := refs[v]; !ok {
+						refs[v] = v
+					}
+				}
+			}
+		}
+		for _, fn := range []string{function, fmt.Sprint(e["function2"])} {
+			for _, col := range functionColumns[fn] {
+				addColumnRef(refs, col)
+			}
+		}
+		if opts, ok := e["options"].(map[string]interface{}); ok {
+			for k, v := range opts {
+				if s, okS := v.(string); okS {
+					optsFound[k] = s
+				}
+			}
+		}
+		for _, v := range e {
+			walkConfig(v, refs, optsFound)
+		}
+	case []interface{}:
+		for _, v := range e {
+			walkConfig(v, refs, optsFound)
+		}
+	}
+}
+
+// addColumnRef adds a column name to refs, keyed as in the expressions
+func addColumnRef(refs map[string]string, col string) {
+	col = strings.ToLower(col)
+	refs[removeSpaces(col)] = col
+}
+
+// expressionVars returns the variables of an expression. Variables are column names with "_" instead of spaces
+func expressionVars(expr string) []string {
+	expression, err := govaluate.NewEvaluableExpressionWithFunctions(strings.ToLower(expr), exprFunctions)
+	if err != nil {
+		return nil
+	}
+	return expression.Vars()
+}
+
+// checkColumns compares the columns referenced by the config with the sheet headers
+func checkColumns(json map[string]interface{}, opts optionsT, header []string) columnCheckT {
+	var result columnCheckT
+	inSheet := make(map[string]bool)
+	for _, h := range header {
+		inSheet[removeSpaces(h)] = true
+	}
+	used := make(map[string]bool)
+	for _, ref := range configColumns(json, opts) {
+		key := removeSpaces(ref)
+		used[key] = true
+		if !inSheet[key] {
+			result.missing = append(result.missing, ref)
+		}
+	}
+	for _, h := range header {
+		if h != "" && !used[removeSpaces(h)] {
+			result.unused = append(result.unused, h)
+		}
+	}
+	return result
+}
+
+// logColumnCheck prints the result of checkColumns. With fatal (validate mode), returns an error if there are
+// missing columns; otherwise they are only warnings
+func logColumnCheck(sheetName string, check columnCheckT, fatal bool) error {
+	for _, col := range check.unused {
+		log(msg(msgUnusedColumn, col, sheetName))
+	}
+	for _, col := range check.missing {
+		if !fatal {
+			log(msg(msgColumnNotInSheet, col, sheetName))
+			continue
+		}
+		logError(newError(msgMissingColumn, col, sheetName))
+	}
+	if fatal && len(check.missing) > 0 {
+		return newError(msgMissingColumns, len(check.missing), sheetName, check.missing)
+	}
+	return nil
+}
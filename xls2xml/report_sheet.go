@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"math"
 	"os"
 	"strconv"
 
@@ -15,6 +14,7 @@ import (
 
 // ReportSheet represents the sheet generated for the provider
 type reportSheet struct {
+	run         *runT
 	filepath    string
 	sheetName   string
 	xlsFile     *xlsx.Spreadsheet
@@ -42,8 +42,9 @@ func (rs *reportSheet) EndMap() error {
 }
 
 // NewReportSheet creates a new struct
-func newReportSheet(filename string, sheetName string, nCols int, nLines int) (*reportSheet, error) {
+func newReportSheet(run *runT, filename string, sheetName string, nCols int, nLines int) (*reportSheet, error) {
 	rSheet := reportSheet{
+		run:        run,
 		filepath:   filename,
 		sheetName:  sheetName,
 		numCols:    nCols,
@@ -63,10 +64,10 @@ func (rs *reportSheet) OpenOutput() error {
 	st, err := os.Stat(rs.filepath)
 	if err == nil {
 		if st.IsDir() {
-			return fmt.Errorf("arquivo [%s] nao pode ser aberto pois e' um diretorio", rs.filepath)
+			return newError(msgFileIsDir, rs.filepath)
 		}
 		if errR := os.Remove(rs.filepath); errR != nil {
-			return fmt.Errorf("arquivo [%s] nao pode ser sobrescrito", rs.filepath)
+			return newError(msgFileNotWritable, rs.filepath)
 		}
 	}
 	err = nil
@@ -105,7 +106,7 @@ func (rs *reportSheet) OpenOutput() error {
 		styles.Fill.Color("#ffffff"),
 		styles.Fill.Background("#ffffff"),
 		styles.Fill.Type(styles.PatternTypeSolid),
-		styles.NumberFormat("\"R$\" #,##0.00"),
+		styles.NumberFormat(fmt.Sprintf("\"%s\" #,##0.00", currencySymbol(rs.run.options["options"]["currency"]))),
 	)
 	rs.moneyStyle = rs.xlsFile.AddStyles(moneyStyle)
 	return nil
@@ -125,7 +126,7 @@ func (rs *reportSheet) writeCell(col int, row int, value interface{}, style styl
 	cell := rs.sheet.Cell(col, row)
 	cell.SetStyles(style)
 	if cell == nil {
-		return fmt.Errorf("celula [%d, %d] na planilha [%s], aba [%s] nao existe", col, row, rs.filepath, rs.sheet.Name())
+		return newError(msgCellNotFound, col, row, rs.filepath, rs.sheet.Name())
 	}
 	cell.SetValue(value)
 	return nil
@@ -139,48 +140,47 @@ func (rs *reportSheet) Write(_ string) error {
 func (rs *reportSheet) WriteAttr(name string, value string, vtype string, _ string) error {
 	//fmt.Printf("name:[%v], value:[%v], vtype:[%v]\n", name, value, vtype)
 	style := rs.bodyStyle
-	ERRS := "#ERRO#"
+	ERRS := errorMessage[0].val
 	var val interface{}
+	var errConv error
 	switch vtype {
 	case "", "string":
 		val = value
 	case "int":
 		v, err := strconv.ParseFloat(value, 64)
 		if err != nil {
-			val = ERRS
+			val, errConv = ERRS, newConversionError(name, value, vtype, err)
 			break
 		}
 		val = v
 	case "money":
-		v, err := strconv.ParseFloat(value, 64)
+		v, err := parseMoney(value, moneyLocale(rs.run.options))
 		if err != nil {
-			val = ERRS
+			val, errConv = ERRS, newConversionError(name, value, vtype, err)
 			break
 		}
 		val = v
 		style = rs.moneyStyle
-	case "time":
-		time, err := toTimeSeconds(value)
+	case "time", "time_s", "time_m", "timestamp":
+		d, err := parseDuration(value)
 		if err != nil {
-			logError(err)
-			val = ERRS
+			val, errConv = ERRS, newConversionError(name, value, vtype, err)
 			break
 		}
-		hours := time / 3600
-		minutes := int64(math.Ceil((float64(time) - float64(hours)*3600) / 60))
-		val = fmt.Sprintf("%02d:%02d", hours, minutes)
-	case "time_s":
-		sec, err := toTimeSeconds(value)
-		if err != nil {
-			logError(err)
-			val = ERRS
-			break
+		switch vtype {
+		case "time":
+			val = d.hoursMinutes()
+		case "time_s":
+			val = d.days()
+			style = rs.timeStyle
+		case "time_m":
+			val = float64(d.minutes())
+		case "timestamp":
+			val = float64(d.millis())
 		}
-		val = float64(sec) / (24.0 * 3600.0)
-		style = rs.timeStyle
 	case "boolean":
 		if value != "true" && value != "false" {
-			val = ERRS
+			val, errConv = ERRS, newConversionError(name, value, vtype, newError(msgInvalidBoolean))
 			break
 		}
 		val = value
@@ -198,7 +198,7 @@ func (rs *reportSheet) WriteAttr(name string, value string, vtype string, _ stri
 		return err
 	}
 	rs.currentCol++
-	return nil
+	return errConv
 }
 
 // WriteAndClose writes the xls file and closes it
@@ -206,6 +206,7 @@ func (rs *reportSheet) WriteAndClose(_ string) error {
 	if err := rs.xlsFile.SaveAs(rs.filepath); err != nil {
 		return err
 	}
+	rs.run.registerOutput(rs.filepath)
 	if err := rs.xlsFile.Close(); err != nil {
 		return err
 	}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+)
+
+// Severities of the validation rules
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// ruleT is a validation rule of the config section 'rules'. The condition must be true for every
+// line of the spreadsheet; if there is a filter, only for the lines where the filter is true
+type ruleT struct {
+	name      string
+	condition string
+	filter    string
+	severity  string
+	message   string
+	field     string // column pointed by the error report
+}
+
+// readRules reads the 'rules' section of the config, checking the expressions
+func readRules(json map[string]interface{}) ([]ruleT, error) {
+	jRules, ok := json["rules"].([]interface{})
+	if !ok {
+		return nil, nil
+	}
+	rules := make([]ruleT, 0, len(jRules))
+	for i, jr := range jRules {
+		m, okM := jr.(map[string]interface{})
+		if !okM {
+			return nil, newError(msgInvalidRule, i+1, jr)
+		}
+		r := ruleT{severity: severityError}
+		r.name, _ = m["Name"].(string)
+		r.condition, _ = m["condition"].(string)
+		r.filter, _ = m["filter"].(string)
+		r.message, _ = m["message"].(string)
+		r.field, _ = m["field"].(string)
+		if sev, okS := m["severity"].(string); okS && sev != "" {
+			r.severity = strings.ToLower(sev)
+		}
+		if r.name == "" {
+			r.name = msg(msgRuleName, i+1)
+		}
+		if r.severity != severityError && r.severity != severityWarning {
+			return nil, newError(msgRuleSeverity, r.name, r.severity,
+				severityError, severityWarning)
+		}
+		if r.condition == "" {
+			return nil, newError(msgRuleNoCondition, r.name)
+		}
+		if r.message == "" {
+			r.message = msg(msgConditionNotMet, r.condition)
+		}
+		for _, expr := range []string{r.condition, r.filter} {
+			if expr == "" {
+				continue
+			}
+			if _, err := newExpression(strings.ToLower(expr)); err != nil {
+				return nil, newError(msgRuleExpression, r.name, expr, err)
+			}
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// checkRules evaluates the rules for each line, returning the violations
+func checkRules(rules []ruleT, lines []lineT) (errs []error) {
+	for i := range lines {
+		for _, r := range rules {
+			if err := r.check(&lines[i]); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return
+}
+
+// check evaluates a rule for a line
+func (r ruleT) check(line *lineT) error {
+	if r.filter != "" {
+		apply, err := evalCondition(strings.ToLower(r.filter), line)
+		if err != nil {
+			return r.violation(line, severityError, newError(msgRuleFilterFailed, r.filter, err))
+		}
+		if !apply {
+			return nil
+		}
+	}
+	ok, err := evalCondition(strings.ToLower(r.condition), line)
+	if err != nil {
+		return r.violation(line, severityError, newError(msgRuleFailed, r.condition, err))
+	}
+	if ok {
+		return nil
+	}
+	return r.violation(line, r.severity, newError(msgRuleViolated, r.message))
+}
+
+// violation creates the error of a line violating a rule
+func (r ruleT) violation(line *lineT, severity string, err error) error {
+	return &cellErrorT{err: err, name: r.name, function: "rule", line: line,
+		header: strings.ToLower(r.field), severity: severity}
+}
+
+// isWarning returns true if the error is only a warning
+func isWarning(err error) bool {
+	cellErr, ok := err.(*cellErrorT)
+	return ok && cellErr.severity == severityWarning
+}
+
+// logRuleErrors logs the violations of the rules, returning true if any of them is an error
+func logRuleErrors(errs []error) (hasErrors bool) {
+	for _, e := range errs {
+		if isWarning(e) {
+			log(msg(msgLogWarning, e))
+			continue
+		}
+		logError(e)
+		hasErrors = true
+	}
+	return
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	xw "github.com/shabbyrobe/xmlwriter"
+)
+
+// runT is the state of a conversion: its options and everything accumulated while the packs are written.
+// Each conversion has its own run, so conversions in the same process (and tests) don't share state
+type runT struct {
+	options      optionsT
+	strict       bool                     // strict mode: any error cancels the batch
+	rs           *reportSheet             // publisher report
+	xlsFilePath  string                   // file of the publisher report
+	uniqChecker  *uniqueCheckerT          // uniqueness of the values across the packs
+	versions     *versionRegistryT        // versions of the assets delivered
+	packages     *packagerT               // delivery packages, nil if not asked
+	merger       *boxMergerT              // previous Box catalog, nil if not merged
+	consolidated interface{}              // assets of the Box JSON
+	mrssItems    map[string][]xw.Elem     // items of the Media RSS feeds, by feed file
+	rowStatus    map[int]rowStatusT       // status of the rows of the main sheet, by row number
+	errorRecords []errorRecordT           // errors of the run
+	outputFiles  []string                 // files written by the run
+	packCount    int                      // packs (lines generating one file) processed
+	checksums    *checksumCacheT          // checksums of the media files, read when first used
+	functions    map[string]userFunctionT // used before functionDict
+}
+
+// newRun creates the run of a config, reading its options
+func newRun(json map[string]interface{}) *runT {
+	functionsOnce.Do(initFunctions)
+	r := &runT{
+		options:   optionsT{"options": make(map[string]string)},
+		mrssItems: make(map[string][]xw.Elem),
+		rowStatus: make(map[int]rowStatusT),
+	}
+	opts, _ := json["options"].([]interface{})
+	for _, el := range opts {
+		m := el.(map[string]interface{})
+		name := m["Name"].(string)
+		value := m["Value"].(string)
+		r.options["options"][name] = value
+	}
+	r.options["options"]["timestamp"] = timestamp()
+	// functions using the state of the run
+	r.functions = map[string]userFunctionT{
+		"media_md5": r.mediaMD5,
+	}
+	return r
+}
+
+// function returns the function of a name: the one of the run, or the one of functionDict
+func (r *runT) function(name string) (userFunctionT, bool) {
+	if f, ok := r.functions[name]; ok {
+		return f, true
+	}
+	f, ok := functionDict[name]
+	return f, ok
+}
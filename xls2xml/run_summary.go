@@ -0,0 +1,120 @@
+package main
+
+import (
+	js "encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+// Exit codes of the process
+const (
+	exitOK      = 0 // all files generated without errors
+	exitUsage   = 1 // invalid command line
+	exitConfig  = 2 // required options missing in the config
+	exitErrors  = 3 // processing finished with errors: rows not generated, _ERRO files or strict batch cancelled
+	exitAborted = 4 // processing aborted: input spreadsheets, config file, missing columns or output directory
+	exitPanic   = 5 // unexpected failure
+)
+
+// Run summary file name, written in the output directory
+const runSummaryFile = "run_summary.json"
+
+// exitCode converts the success value computed by main to the exit code of the process. -3 is set for
+// the errors that abort the processing
+func exitCode(success int) int {
+	switch success {
+	case 0:
+		return exitOK
+	case 1:
+		return exitUsage
+	case 2:
+		return exitConfig
+	case -1:
+		return exitErrors
+	case -4:
+		return exitPanic
+	}
+	return exitAborted
+}
+
+// runSummaryT is the summary of a run, written in runSummaryFile
+type runSummaryT struct {
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	Duration     float64                `json:"duration_seconds"`
+	ExitCode     int                    `json:"exit_code"`
+	Xls          string                 `json:"xls"`
+	XlsCat       string                 `json:"xlscat,omitempty"`
+	Config       string                 `json:"config"`
+	OutType      string                 `json:"outtype"`
+	OutDir       string                 `json:"outdir"`
+	Strict       bool                   `json:"strict"`
+	ValidateOnly bool                   `json:"validate_only"`
+	Rows         int                    `json:"rows"`
+	Packs        int                    `json:"packs"`
+	Files        int                    `json:"files"`
+	ErrorFiles   int                    `json:"error_files"`
+	Written      []string               `json:"files_written"`
+	Warnings     []errorRecordT         `json:"warnings"`
+	Errors       []errorRecordT         `json:"errors"`
+	Merge        map[string]mergeCountT `json:"merge,omitempty"` // changes of the Box catalog, by file
+	ready        bool                   // command line checked, the summary can be written
+}
+
+// newRunSummary starts the summary of a run
+func newRunSummary() *runSummaryT {
+	return &runSummaryT{Start: time.Now(), Written: []string{}, Warnings: []errorRecordT{}, Errors: []errorRecordT{}}
+}
+
+// finish completes the summary with the results of the run. err is the error that aborted the run, if any.
+// run is nil if the run did not start
+func (s *runSummaryT) finish(run *runT, success int, err error) {
+	s.End = time.Now()
+	s.Duration = s.End.Sub(s.Start).Seconds()
+	s.ExitCode = exitCode(success)
+	records := []errorRecordT{}
+	if run != nil {
+		s.Packs = run.packCount
+		for _, f := range run.outputFiles {
+			if _, errS := os.Stat(f); errS != nil {
+				// removed by the run or discarded in strict mode
+				continue
+			}
+			s.Written = append(s.Written, f)
+			s.Files++
+			if !deliverable(f) {
+				s.ErrorFiles++
+			}
+		}
+		records = append(records, run.errorRecords...)
+		if run.merger != nil {
+			s.Merge = run.merger.counts
+		}
+	}
+	if err != nil {
+		records = append(records, newErrorRecord(err))
+	}
+	for _, rec := range records {
+		if rec.Severity == severityWarning {
+			s.Warnings = append(s.Warnings, rec)
+		} else {
+			s.Errors = append(s.Errors, rec)
+		}
+	}
+}
+
+// write writes the summary in the output directory
+func (s *runSummaryT) write() error {
+	buf, err := js.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	filename := path.Join(s.OutDir, runSummaryFile)
+	log(msg(msgSaving, filename))
+	if err = ioutil.WriteFile(filename, buf, 0644); err != nil {
+		return newError(msgCreateFile, filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Options of the Box series
+const (
+	seriesModeOpt        = "series_mode"         // sheet (default): series of the 'series' sheet; derive: series of the main sheet
+	seriesTitleColumnOpt = "series_title_column" // column of the main sheet with the series title, also read by series_id and season_id. Default: título original
+)
+
+// Column of the main sheet with the season number, as read by series_id and season_id
+const seriesSeasonColumn = "temporada"
+
+// Namespace of the ids of the derived series and seasons
+var seriesNamespace = uuid.NewV5(uuid.NamespaceURL, "xls2xml/series")
+
+// deriveSeries returns the lines of the series of the main sheet, as if read from the 'series' sheet: a line
+// for each season of the rows with title and season, plus the line of season -1 with the series images.
+// The ids are derived from the title and the season, so they don't change between runs. Synopses, images and
+// translated titles come from the lines of the 'series' sheet with the same title and season, if any
+func deriveSeries(lines []lineT, overrides []lineT, options optionsT) ([]lineT, error) {
+	titleCol := strings.ToLower(options["options"][seriesTitleColumnOpt])
+	if titleCol == "" {
+		titleCol = "título original"
+	}
+	var titles []string
+	seasons := make(map[string][]int)
+	for i := range lines {
+		title := strings.TrimSpace(lines[i].fields[titleCol])
+		season := strings.TrimSpace(lines[i].fields[seriesSeasonColumn])
+		if title == "" || season == "" {
+			// not an episode
+			continue
+		}
+		n, err := strconv.Atoi(season)
+		if err != nil {
+			return nil, &cellErrorT{err: newError(msgDerivedSeason, season, title), name: "series", function: "series",
+				line: &lines[i], header: seriesSeasonColumn}
+		}
+		if _, ok := seasons[title]; !ok {
+			titles = append(titles, title)
+		}
+		if !containsInt(seasons[title], n) {
+			seasons[title] = append(seasons[title], n)
+		}
+	}
+	options["series"] = make(map[string]string)
+	serieLines := make([]lineT, 0)
+	nSeasons := 0
+	for _, title := range titles {
+		id := uuid.NewV5(seriesNamespace, title).String()
+		sort.Ints(seasons[title])
+		for _, n := range seasons[title] {
+			season := strconv.Itoa(n)
+			idSeason := uuid.NewV5(seriesNamespace, title+"|"+season).String()
+			line := newLineT(0)
+			line.fields = map[string]string{"id": id, "id season": idSeason, "season": season, "title": "por:" + title,
+				"synopsis": "por:", "season synopsis": "por:"}
+			overrideSeries(line.fields, overrides, title, season)
+			serieLines = append(serieLines, line)
+			options["series"][title+"|"+season] = id + "|" + idSeason
+			nSeasons++
+		}
+		images := newLineT(0)
+		images.fields = map[string]string{"id": id, "season": "-1"}
+		if overrideSeries(images.fields, overrides, title, "-1") {
+			serieLines = append(serieLines, images)
+		}
+	}
+	log(msg(msgSeriesDerived, len(titles), nSeasons))
+	return serieLines, nil
+}
+
+// overrideSeries copies to a derived line the values of the line of the 'series' sheet with the same title,
+// in any language, and season. Returns true if the line was found
+func overrideSeries(fields map[string]string, overrides []lineT, title string, season string) bool {
+	// the lines of the series images may have only the id
+	sheetID := ""
+	for _, o := range overrides {
+		if names, err := splitLangName(o.fields["title"]); err == nil && containsValue(names, title) {
+			sheetID = o.fields["id"]
+			break
+		}
+	}
+	for _, o := range overrides {
+		if strings.TrimSpace(o.fields["season"]) != season {
+			continue
+		}
+		names, err := splitLangName(o.fields["title"])
+		if (err != nil || !containsValue(names, title)) && (sheetID == "" || o.fields["id"] != sheetID) {
+			continue
+		}
+		for _, col := range []string{"title", "synopsis", "season synopsis", "capa", "landscape"} {
+			if val := strings.TrimSpace(o.fields[col]); val != "" {
+				fields[col] = val
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// containsInt returns true if the slice contains the value
+func containsInt(s []int, e int) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
+
+// containsValue returns true if the map has the value
+func containsValue(m map[string]string, val string) bool {
+	for _, v := range m {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
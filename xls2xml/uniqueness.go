@@ -0,0 +1,158 @@
+package main
+
+import (
+	js "encoding/json"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Pseudo column used for the uniqueness of the output filenames
+const uniqueFilename = "arquivo"
+
+// uniqueRefT identifies where a value of a unique column was found
+type uniqueRefT struct {
+	File  string `json:"file"`
+	Sheet string `json:"sheet,omitempty"`
+	Row   int    `json:"row,omitempty"`
+	Date  string `json:"date,omitempty"`
+	line  *lineT
+}
+
+// uniqueIndexT holds the values of the unique columns: column -> value -> reference
+type uniqueIndexT map[string]map[string]uniqueRefT
+
+// uniqueCheckerT checks the uniqueness constraints across the packs of a batch and, optionally,
+// against an index of previous deliveries
+type uniqueCheckerT struct {
+	columns   []string     // unique columns, lowercase
+	seen      uniqueIndexT // values of the current batch
+	indexFile string
+	delivered uniqueIndexT // values of previous deliveries
+	timestamp string       // date of the run
+}
+
+// newUniqueChecker reads the options 'unique_fields' (comma separated column names) and
+// 'unique_index' (file with the values delivered before). Output filenames are always unique
+func newUniqueChecker(opts optionsT) (*uniqueCheckerT, error) {
+	u := &uniqueCheckerT{
+		seen:      make(uniqueIndexT),
+		indexFile: opts["options"]["unique_index"],
+		delivered: make(uniqueIndexT),
+		timestamp: opts["options"]["timestamp"],
+	}
+	for _, col := range strings.Split(opts["options"]["unique_fields"], ",") {
+		if col = strings.ToLower(strings.TrimSpace(col)); col != "" {
+			u.columns = append(u.columns, col)
+		}
+	}
+	if u.indexFile == "" {
+		return u, nil
+	}
+	if err := readJSONFile(u.indexFile, &u.delivered, msgUniqueIndex); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// checkPack verifies the values of the unique columns of a pack, returning an error for each
+// conflict. Values found without conflicts are recorded
+func (u *uniqueCheckerT) checkPack(pack []lineT, filename string) (errs []error) {
+	if len(pack) == 0 {
+		return
+	}
+	file := path.Base(filename)
+	values := map[string]uniqueRefT{strings.ToLower(file): u.newRef(file, &pack[0])}
+	columns := map[string]string{strings.ToLower(file): uniqueFilename}
+	for _, col := range u.columns {
+		for i := range pack {
+			val := strings.TrimSpace(pack[i].fields[col])
+			if val == "" {
+				continue
+			}
+			key := col + "\x00" + val
+			if _, ok := values[key]; !ok {
+				values[key] = u.newRef(file, &pack[i])
+				columns[key] = col
+			}
+		}
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		col, ref := columns[key], values[key]
+		val := key
+		if col != uniqueFilename {
+			val = strings.TrimPrefix(key, col+"\x00")
+		}
+		header := col
+		if col == uniqueFilename {
+			header = ""
+		}
+		if prev, ok := u.seen[col][val]; ok {
+			errs = append(errs, uniqueConflict(ref.line, header, newError(
+				msgUniqueRepeated, val, col, prev.Row, ref.Row)))
+			if prev.line != nil {
+				errs = append(errs, uniqueConflict(prev.line, header, newError(
+					msgUniqueRepeated, val, col, prev.Row, ref.Row)))
+			}
+			continue
+		}
+		if prev, ok := u.delivered[col][val]; ok && prev.File != file {
+			errs = append(errs, uniqueConflict(ref.line, header, newError(
+				msgUniqueDelivered,
+				val, col, prev.File, prev.Sheet, prev.Row, prev.Date)))
+			continue
+		}
+		if u.seen[col] == nil {
+			u.seen[col] = make(map[string]uniqueRefT)
+		}
+		u.seen[col][val] = ref
+	}
+	return
+}
+
+// newRef creates a reference to a line of the current batch
+func (u *uniqueCheckerT) newRef(file string, line *lineT) uniqueRefT {
+	ref := uniqueRefT{File: file, Row: line.idx, Date: u.timestamp, line: line}
+	if line.sheet != nil {
+		ref.Sheet = line.sheet.name
+	}
+	return ref
+}
+
+// uniqueConflict creates the error of a line violating a uniqueness constraint
+func uniqueConflict(line *lineT, header string, err error) error {
+	return &cellErrorT{err: err, name: "unicidade", function: "unique", line: line, header: header}
+}
+
+// saveIndex adds the values of the delivered rows to the index of previous deliveries
+func (u *uniqueCheckerT) saveIndex(delivered func(row int) bool) error {
+	if u.indexFile == "" {
+		return nil
+	}
+	for col, values := range u.seen {
+		for val, ref := range values {
+			if !delivered(ref.Row) {
+				continue
+			}
+			if u.delivered[col] == nil {
+				u.delivered[col] = make(map[string]uniqueRefT)
+			}
+			u.delivered[col][val] = ref
+		}
+	}
+	buf, err := js.MarshalIndent(u.delivered, "", "  ")
+	if err != nil {
+		return err
+	}
+	log(msg(msgSaving, u.indexFile))
+	if err = ioutil.WriteFile(u.indexFile, buf, 0644); err != nil {
+		return newError(msgCreateFile, u.indexFile, err)
+	}
+	return nil
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	js "encoding/json"
 	"fmt"
 	uuid "github.com/satori/go.uuid"
 	"golang.org/x/text/encoding/charmap"
@@ -8,13 +10,37 @@ import (
 	"golang.org/x/text/transform"
 	"golang.org/x/text/unicode/norm"
 	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 	"unicode"
+	"unicode/utf8"
 )
 
+// readJSONFile decodes a JSON file kept between runs into v, with the numbers of interface{} values as
+// js.Number. A missing file is the first delivery and leaves v unchanged. Errors are reported with the
+// message code of the file
+func readJSONFile(file string, v interface{}, code string) error {
+	buf, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		// first delivery
+		return nil
+	}
+	if err != nil {
+		return newError(code, file, err)
+	}
+	dec := js.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	if err = dec.Decode(v); err != nil {
+		return newError(code, file, err)
+	}
+	return nil
+}
+
 // RemoveSpaces replaces all whitespace with "_"
 func removeSpaces(val string) string {
 	return strings.Join(strings.Fields(val), "_")
@@ -70,15 +96,156 @@ func replaceAllNonAlpha(val string) (string, error) {
 	return b.String(), nil
 }
 
+// moneySeparators maps a locale to its decimal and grouping separators
+var moneySeparators = map[string][2]string{
+	"pt-BR": {",", "."},
+	"en-US": {".", ","},
+}
+
+// currencySymbols maps a currency code to its symbol
+var currencySymbols = map[string]string{
+	"BRL": "R$",
+	"USD": "US$",
+	"EUR": "€",
+}
+
+const defaultCurrency = "BRL"
+
+// currencyLocales maps a currency code to the locale of its values, used when 'money_locale' is not set
+var currencyLocales = map[string]string{
+	"BRL": "pt-BR",
+	"USD": "en-US",
+}
+
+// moneyLocale returns the locale of the money values: the option 'money_locale', or the locale of the
+// option 'currency', BRL if not set
+func moneyLocale(options optionsT) string {
+	if locale := options["options"]["money_locale"]; locale != "" {
+		return locale
+	}
+	currency := strings.ToUpper(strings.TrimSpace(options["options"]["currency"]))
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	return currencyLocales[currency]
+}
+
+var reMoneyStrip = regexp.MustCompile(`(?i)(R\$|US\$|\$|€|BRL|USD|EUR|\s)`)
+
+// parseMoney reads a money value written with pt-BR or en-US separators, with or without a currency symbol
+// ("R$ 12,90", "1.234,50", "1,234.50", "USD 9.99").
+// When a single separator is followed by exactly 3 digits ("1.234") the locale decides if it is a decimal
+// separator; without a locale the '.' is decimal, as in strconv.ParseFloat
+func parseMoney(val string, locale string) (float64, error) {
+	v := reMoneyStrip.ReplaceAllString(val, "")
+	if v == "" {
+		return 0, newError(msgEmptyMoney, val)
+	}
+	lastDot := strings.LastIndex(v, ".")
+	lastComma := strings.LastIndex(v, ",")
+	decimal := ""
+	switch {
+	case lastDot >= 0 && lastComma >= 0:
+		// both separators: the last one is the decimal separator
+		if lastDot > lastComma {
+			decimal = "."
+		} else {
+			decimal = ","
+		}
+	case lastDot >= 0 || lastComma >= 0:
+		sep, idx := ".", lastDot
+		if lastComma >= 0 {
+			sep, idx = ",", lastComma
+		}
+		if strings.Count(v, sep) > 1 {
+			// repeated separator: grouping
+			break
+		}
+		if len(v)-idx-1 != 3 {
+			decimal = sep
+			break
+		}
+		// ambiguous: "1.234" or "1,234"
+		if seps, ok := moneySeparators[locale]; ok {
+			if seps[0] == sep {
+				decimal = sep
+			}
+		} else if sep == "." {
+			decimal = sep
+		}
+	}
+	var b strings.Builder
+	for _, r := range v {
+		switch {
+		case string(r) == decimal:
+			b.WriteRune('.')
+		case r == '.' || r == ',':
+			// grouping separator: skip
+		default:
+			b.WriteRune(r)
+		}
+	}
+	res, err := strconv.ParseFloat(b.String(), 64)
+	if err != nil {
+		return 0, newError(msgInvalidMoney, val)
+	}
+	return res, nil
+}
+
+// formatMoneyAs formats a money value. Formats: "decimal" (default, "1234.50"), "locale" ("1.234,50"),
+// "symbol" ("R$ 1.234,50") and "code" ("BRL 1234.50")
+func formatMoneyAs(value float64, format string, locale string, currency string) (string, error) {
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	dec := fmt.Sprintf("%.2f", value)
+	switch format {
+	case "", "decimal":
+		return dec, nil
+	case "code":
+		return currency + " " + dec, nil
+	case "locale", "symbol":
+		seps, ok := moneySeparators[locale]
+		if !ok {
+			seps = moneySeparators["pt-BR"]
+		}
+		neg := strings.HasPrefix(dec, "-")
+		dec = strings.TrimPrefix(dec, "-")
+		intPart, fracPart := dec[:len(dec)-3], dec[len(dec)-2:]
+		var b strings.Builder
+		for i, r := range intPart {
+			if i > 0 && (len(intPart)-i)%3 == 0 {
+				b.WriteString(seps[1])
+			}
+			b.WriteRune(r)
+		}
+		result := b.String() + seps[0] + fracPart
+		if neg {
+			result = "-" + result
+		}
+		if format == "symbol" {
+			result = currencySymbol(currency) + " " + result
+		}
+		return result, nil
+	}
+	return "##ERRO##", newError(msgMoneyFormat, format)
+}
+
+// formatMoney normalizes a money value to the "decimal" format
 func formatMoney(val string) (string, error) {
+	return formatMoneyLocale(val, "", "", "")
+}
+
+// formatMoneyLocale parses a money value in the given locale and formats it
+func formatMoneyLocale(val string, format string, locale string, currency string) (string, error) {
 	if val == "" {
 		return "", nil
 	}
-	res, err := strconv.ParseFloat(val, 64)
+	res, err := parseMoney(val, locale)
 	if err != nil {
 		return "##ERRO##", err
 	}
-	return fmt.Sprintf("%.2f", res), nil
+	return formatMoneyAs(res, format, locale, currency)
 }
 
 //func date() string {
@@ -98,10 +265,6 @@ func formatTimestamp(t time.Time) string {
 	return t.Format("060102150405")
 }
 
-func formatHMS(t time.Time) string {
-	return t.Format("15:04:05")
-}
-
 func formatDate(t time.Time) string {
 	return t.Format("2006-01-02")
 }
@@ -113,15 +276,14 @@ func parseDate(dateStr string) (time.Time, error) {
 }
 
 // truncates element in max chars, preserving suffix
-func truncateSuffix(value string, suffix string, max int) (string, error) {
+func truncateSuffix(value string, suffix string, max int, options optionsT) (string, error) {
 	valLen := len(value)
 	sufLen := len(suffix)
 	if valLen+sufLen <= max {
 		return value, nil
 	}
 	if sufLen+1 >= max {
-		return errorMessage[0].val, fmt.Errorf("sufixo [%s] nao pode ser aplicado porque estoura "+
-			"o tamanho maximo [%d] no elemento [%s]", suffix, max, value)
+		return errorMessage[0].val, newError(msgSuffixOverflow, suffix, max, value)
 	}
 	r := []rune(value)
 	if l := len(r); max > l {
@@ -131,26 +293,26 @@ func truncateSuffix(value string, suffix string, max int) (string, error) {
 		max--
 	}
 	safeSubstring := string(r[0:max])
-	return testInvalidChars(safeSubstring)
+	return testInvalidChars(safeSubstring, options)
 }
 
-func truncate(value string, _ *lineT, json jsonT, _ optionsT) (string, error) {
+func truncate(value string, _ *lineT, json jsonT, options optionsT) (string, error) {
 	val, err := getValue("maxlength", json)
 	if val == "" || err != nil {
-		return testInvalidChars(value)
+		return testInvalidChars(value, options)
 	}
 	max, errA := strconv.Atoi(val)
 	if errA != nil {
-		return errorMessage[0].val, fmt.Errorf("valor nao numerico em maxlenght: [%v]", val)
+		return errorMessage[0].val, newError(msgMaxLengthNotNumeric, val)
 	}
 	r := []rune(value)
 	if len(r) <= max {
 		// size ok, return
-		return testInvalidChars(value)
+		return testInvalidChars(value, options)
 	}
 	// truncate
 	safeSubstring := string(r[0:max])
-	return testInvalidChars(safeSubstring)
+	return testInvalidChars(safeSubstring, options)
 }
 
 //func appendIfNotNil(orig []string, values ...string) []string {
@@ -182,29 +344,89 @@ func timeToUTCTimestamp(t time.Time) int64 {
 //	return time.Parse(ISO8601, value)
 //}
 
-// ToTimestamp converts a numeric string to a timestamp in milliseconds
-func toTimestamp(value string) (int64, error) {
-	//is serial format?
-	serial, err := strconv.ParseFloat(value, 64)
-	if err != nil {
-		// conversion from float failed: try to convert from string
-		t, err2 := time.Parse("15:04:05", value)
-		if err2 != nil {
-			return -1, err
+// durationT is a length of time in whole seconds
+type durationT int64
+
+var reDurationHMS = regexp.MustCompile(`^(\d+):([0-5]?\d)(?::([0-5]?\d(?:\.\d+)?))?$`)
+var reDurationMin = regexp.MustCompile(`^(\d+(?:[.,]\d+)?)\s*(?:min|m|')$`)
+var reDurationISO = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// parseDuration reads a duration in any of the notations found in the spreadsheets:
+// Excel day fractions ("0.0158449"), H:MM:SS or H:MM with hours over 24 ("26:03:10"),
+// minutes ("95min", "95'") and ISO 8601 ("PT1H2M3S").
+// Fractions of a second are rounded to the nearest second.
+func parseDuration(value string) (durationT, error) {
+	v := strings.TrimSpace(value)
+	if v == "" {
+		return 0, newError(msgEmptyDuration)
+	}
+	// is serial format?
+	if serial, err := strconv.ParseFloat(v, 64); err == nil {
+		if math.IsNaN(serial) || math.IsInf(serial, 0) {
+			return 0, newError(msgDurationFormat, value)
+		}
+		if serial < 0 {
+			return 0, newError(msgNegativeDuration, value)
+		}
+		return durationT(math.Round(serial * 86400)), nil
+	}
+	if m := reDurationHMS.FindStringSubmatch(v); m != nil {
+		h, _ := strconv.ParseFloat(m[1], 64)
+		min, _ := strconv.ParseFloat(m[2], 64)
+		sec := 0.0
+		if m[3] != "" {
+			sec, _ = strconv.ParseFloat(m[3], 64)
 		}
-		return int64(uint64(t.Hour())*3600+uint64(t.Minute())*60+uint64(t.Second())) * 1000, nil
+		return durationT(math.Round(h*3600 + min*60 + sec)), nil
 	}
-	return int64(serial * 86400 * 1000), nil
+	if m := reDurationMin.FindStringSubmatch(strings.ToLower(v)); m != nil {
+		min, _ := strconv.ParseFloat(strings.Replace(m[1], ",", ".", 1), 64)
+		return durationT(math.Round(min * 60)), nil
+	}
+	iso := strings.ToUpper(v)
+	if m := reDurationISO.FindStringSubmatch(iso); m != nil && iso != "P" && !strings.HasSuffix(iso, "T") {
+		var total float64
+		for i, mult := range []float64{86400, 3600, 60, 1} {
+			if m[i+1] != "" {
+				n, _ := strconv.ParseFloat(m[i+1], 64)
+				total += n * mult
+			}
+		}
+		return durationT(math.Round(total)), nil
+	}
+	return 0, newError(msgDurationFormat, value)
 }
 
-// ToTimeSeconds converts a numeric string to a timestamp in seconds
-func toTimeSeconds(value string) (int64, error) {
-	//is serial format?
-	serial, err := strconv.ParseFloat(value, 64)
-	if err != nil {
-		return -1, err
-	}
-	return int64(serial * 86400), nil
+// seconds returns the duration in seconds
+func (d durationT) seconds() int64 {
+	return int64(d)
+}
+
+// minutes returns the duration in minutes, rounding any remaining seconds up
+func (d durationT) minutes() int64 {
+	return (int64(d) + 59) / 60
+}
+
+// millis returns the duration in milliseconds
+func (d durationT) millis() int64 {
+	return int64(d) * 1000
+}
+
+// hoursMinutes formats the duration as HH:MM, rounding any remaining seconds up
+func (d durationT) hoursMinutes() string {
+	min := d.minutes()
+	return fmt.Sprintf("%02d:%02d", min/60, min%60)
+}
+
+// hms formats the duration as HH:MM:SS; hours are not limited to 24
+func (d durationT) hms() string {
+	sec := int64(d)
+	return fmt.Sprintf("%02d:%02d:%02d", sec/3600, sec%3600/60, sec%60)
+}
+
+// days returns the duration as an Excel day fraction
+func (d durationT) days() float64 {
+	return float64(d) / 86400.0
 }
 
 func formatNumberString(s string) (string, error) {
@@ -218,7 +440,12 @@ func formatNumberString(s string) (string, error) {
 	}
 }
 
-func testInvalidChars(s string) (string, error) {
+// testInvalidChars checks if the string can be written in the output encoding of the options. In
+// ISO-8859-1, dashes are replaced by hyphens
+func testInvalidChars(s string, options optionsT) (string, error) {
+	if enc, _ := outputEncoding(options); enc != encodingLatin1 {
+		return testInvalidUnicode(s)
+	}
 	_, err := charmap.ISO8859_1.NewEncoder().String(s)
 	if err != nil {
 		result := make([]rune, 0)
@@ -243,7 +470,7 @@ func testInvalidChars(s string) (string, error) {
 			result = append(result, b)
 		}
 		if len(errors) > 0 {
-			return "#ERRO#", fmt.Errorf("%d caracter(es) invalido(s) [%v] na string [%s]", len(errors), string(errors), s)
+			return "#ERRO#", newError(msgInvalidChars, len(errors), string(errors), s)
 		}
 		return string(result), nil
 	}
@@ -275,3 +502,42 @@ func UUIDfromString(s string) (string, error) {
 	}
 	return uu.String(), nil
 }
+
+// currencySymbol returns the symbol of a currency code, defaulting to BRL
+func currencySymbol(currency string) string {
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	if symbol, ok := currencySymbols[currency]; ok {
+		return symbol
+	}
+	return currency
+}
+
+// testInvalidUnicode checks if the string has only valid UTF-8 characters allowed in XML
+func testInvalidUnicode(s string) (string, error) {
+	errors := make([]rune, 0)
+	for i, r := range s {
+		if r == utf8.RuneError {
+			if _, size := utf8.DecodeRuneInString(s[i:]); size == 1 {
+				errors = append(errors, r)
+			}
+			continue
+		}
+		if !isXMLChar(r) {
+			errors = append(errors, r)
+		}
+	}
+	if len(errors) > 0 {
+		return "#ERRO#", newError(msgInvalidChars, len(errors), string(errors), s)
+	}
+	return s, nil
+}
+
+// isXMLChar returns true if the rune is a character allowed in XML 1.0
+func isXMLChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"crypto/md5"
+	js "encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Options of the version registry
+const (
+	versionRegistryOpt = "version_registry"  // file with the versions delivered before
+	verbFieldOpt       = "verb_field"        // column with the action of the row: DELETE removes the package
+	versionKeyFieldOpt = "version_key_field" // column with the stable id of the asset. Default: the option 'id_field'
+)
+
+const verbDelete = "DELETE"
+
+// Actions of a pack, compared to the last delivery
+const (
+	versionNew = iota
+	versionUnchanged
+	versionUpdate
+	versionDelete
+)
+
+// versionEntryT is the last delivered version of an asset, keyed by Asset_ID in the registry
+type versionEntryT struct {
+	Key     string `json:"key"` // id of the row of the asset, which does not change with the name of the file
+	File    string `json:"file"`
+	Class   string `json:"asset_class"`
+	Seq     int    `json:"seq"` // order of the asset among the assets of the same class of the row
+	Major   int    `json:"version_major"`
+	Minor   int    `json:"version_minor"`
+	Hash    string `json:"hash"`
+	Date    string `json:"date"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// versionRegistryT holds the versions of the delivered assets. Update packages reuse the Asset_ID
+// of the last delivery, incrementing Version_Minor
+type versionRegistryT struct {
+	file      string
+	verbField string
+	keyField  string
+	delivered map[string]versionEntryT         // Asset_ID -> last delivered version
+	pending   map[int]map[string]versionEntryT // row -> versions written by the current run
+	timestamp string                           // date of the run
+}
+
+// newVersionRegistry reads the options 'version_registry', 'verb_field' and 'version_key_field'. Without a
+// registry file, the versions of the config are written unchanged
+func newVersionRegistry(opts optionsT) (*versionRegistryT, error) {
+	v := &versionRegistryT{
+		file:      opts["options"][versionRegistryOpt],
+		verbField: strings.ToLower(opts["options"][verbFieldOpt]),
+		keyField:  strings.ToLower(opts["options"][versionKeyFieldOpt]),
+		delivered: make(map[string]versionEntryT),
+		pending:   make(map[int]map[string]versionEntryT),
+		timestamp: opts["options"]["timestamp"],
+	}
+	if v.file == "" {
+		return v, nil
+	}
+	if v.keyField == "" {
+		v.keyField = strings.ToLower(opts["options"]["id_field"])
+	}
+	if v.keyField == "" {
+		return nil, newError(msgOptionValue, versionKeyFieldOpt, "")
+	}
+	if err := readJSONFile(v.file, &v.delivered, msgVersionRegistry); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// wrap returns the writer of a pack, rewriting the AMS elements with the versions of the registry. The
+// assets delivered before are the ones with the id of the row, whatever the name of their file
+func (v *versionRegistryT) wrap(wr writer, pack []lineT, filename string) (writer, error) {
+	if v.file == "" || len(pack) == 0 {
+		return wr, nil
+	}
+	key := strings.TrimSpace(pack[0].fields[v.keyField])
+	if key == "" {
+		return nil, &cellErrorT{err: newError(msgVersionKey, v.keyField), name: "AMS", function: "version",
+			line: &pack[0], header: v.keyField}
+	}
+	vw := &versionWriter{writer: wr, reg: v, row: pack[0].idx, key: key, file: path.Base(filename),
+		hash: v.packHash(pack), prev: make(map[string]string), seq: make(map[string]int)}
+	deleted := false
+	for id, e := range v.delivered {
+		if e.Key == key {
+			vw.prev[e.Class+"\x00"+strconv.Itoa(e.Seq)] = id
+			deleted = deleted || e.Deleted
+		}
+	}
+	isDelete := v.verbField != "" && strings.EqualFold(strings.TrimSpace(pack[0].fields[v.verbField]), verbDelete)
+	switch {
+	case isDelete && (len(vw.prev) == 0 || deleted):
+		return nil, &cellErrorT{err: newError(msgVersionNoPrev, key), name: "AMS", function: "version",
+			line: &pack[0], header: v.verbField}
+	case isDelete:
+		vw.action = versionDelete
+		log(msg(msgVersionDelete, vw.file))
+	case len(vw.prev) == 0 || deleted:
+		// delivered again after a deletion: new assets
+		vw.prev = map[string]string{}
+		vw.action = versionNew
+	default:
+		vw.action = versionUpdate
+		for _, id := range vw.prev {
+			if v.delivered[id].Hash == vw.hash {
+				vw.action = versionUnchanged
+			}
+		}
+		if vw.action == versionUpdate {
+			log(msg(msgVersionUpdate, vw.file))
+		} else {
+			log(msg(msgVersionUnchanged, vw.file))
+		}
+	}
+	return vw, nil
+}
+
+// packHash returns the hash of the values of a pack, ignoring the verb column
+func (v *versionRegistryT) packHash(pack []lineT) string {
+	h := md5.New()
+	for _, line := range pack {
+		keys := make([]string, 0, len(line.fields))
+		for k := range line.fields {
+			if k != "file_number" && k != v.verbField {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			_, _ = fmt.Fprintf(h, "%s=%s\n", k, line.fields[k])
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// save adds the versions of the delivered rows to the registry
+func (v *versionRegistryT) save(delivered func(row int) bool) error {
+	if v.file == "" {
+		return nil
+	}
+	for row, entries := range v.pending {
+		if !delivered(row) {
+			continue
+		}
+		for id, e := range entries {
+			v.delivered[id] = e
+		}
+	}
+	buf, err := js.MarshalIndent(v.delivered, "", "  ")
+	if err != nil {
+		return err
+	}
+	log(msg(msgSaving, v.file))
+	if err = ioutil.WriteFile(v.file, buf, 0644); err != nil {
+		return newError(msgCreateFile, v.file, err)
+	}
+	return nil
+}
+
+// amsAttrT is an attribute of an AMS element, held until the element is closed
+type amsAttrT struct {
+	name, value, vtype, attrType string
+}
+
+// versionWriter writes a pack, replacing Asset_ID, Version_Major and Version_Minor of the AMS
+// elements by the values of the registry. Delete packages get the attribute Verb="DELETE"
+type versionWriter struct {
+	writer
+	reg    *versionRegistryT
+	row    int
+	key    string
+	file   string
+	hash   string
+	action int
+	prev   map[string]string // class + seq -> Asset_ID of the last delivery
+	seq    map[string]int
+	inAMS  bool
+	attrs  []amsAttrT
+}
+
+// StartElem opens an element. The attributes of AMS are held until EndElem
+func (vw *versionWriter) StartElem(name string, t elemType) error {
+	if name == "AMS" {
+		vw.inAMS, vw.attrs = true, nil
+	}
+	return vw.writer.StartElem(name, t)
+}
+
+// WriteAttr writes an attribute
+func (vw *versionWriter) WriteAttr(name string, value string, vtype string, attrType string) error {
+	if vw.inAMS {
+		vw.attrs = append(vw.attrs, amsAttrT{name, value, vtype, attrType})
+		return nil
+	}
+	return vw.writer.WriteAttr(name, value, vtype, attrType)
+}
+
+// EndElem closes an element, writing the attributes of AMS
+func (vw *versionWriter) EndElem(name string, t elemType) error {
+	if name == "AMS" && vw.inAMS {
+		vw.inAMS = false
+		if err := vw.writeAMS(); err != nil {
+			return err
+		}
+	}
+	return vw.writer.EndElem(name, t)
+}
+
+// writeAMS writes the attributes of an AMS element with the version of the asset
+func (vw *versionWriter) writeAMS() error {
+	values := make(map[string]string)
+	for _, a := range vw.attrs {
+		values[a.name] = a.value
+	}
+	class := strings.ToLower(values["Asset_Class"])
+	seq := vw.seq[class]
+	vw.seq[class]++
+	id := values["Asset_ID"]
+	e := versionEntryT{Key: vw.key, File: vw.file, Class: class, Seq: seq, Hash: vw.hash, Date: vw.reg.timestamp}
+	var err error
+	if e.Major, err = versionNumber("Version_Major", values); err != nil {
+		return err
+	}
+	if e.Minor, err = versionNumber("Version_Minor", values); err != nil {
+		return err
+	}
+	if prevID, ok := vw.prev[class+"\x00"+strconv.Itoa(seq)]; ok && vw.action != versionNew {
+		prev := vw.reg.delivered[prevID]
+		id = prevID
+		// a Version_Major greater than the delivered one is kept, with the Version_Minor of the config
+		if e.Major <= prev.Major {
+			e.Major, e.Minor = prev.Major, prev.Minor
+			if vw.action != versionUnchanged {
+				e.Minor++
+			}
+		}
+		e.Deleted = vw.action == versionDelete
+	}
+	hasVerb := false
+	for i, a := range vw.attrs {
+		switch a.name {
+		case "Asset_ID":
+			a.value = id
+		case "Version_Major":
+			a.value = strconv.Itoa(e.Major)
+		case "Version_Minor":
+			a.value = strconv.Itoa(e.Minor)
+		case "Verb":
+			hasVerb = true
+			if e.Deleted {
+				a.value = verbDelete
+			}
+		}
+		vw.attrs[i] = a
+	}
+	if e.Deleted && !hasVerb {
+		vw.attrs = append(vw.attrs, amsAttrT{name: "Verb", value: verbDelete, vtype: "string"})
+	}
+	for _, a := range vw.attrs {
+		if err = vw.writer.WriteAttr(a.name, a.value, a.vtype, a.attrType); err != nil {
+			return err
+		}
+	}
+	if vw.reg.pending[vw.row] == nil {
+		vw.reg.pending[vw.row] = make(map[string]versionEntryT)
+	}
+	vw.reg.pending[vw.row][id] = e
+	return nil
+}
+
+// versionNumber returns the value of a version attribute of AMS. Missing attributes are 0
+func versionNumber(name string, values map[string]string) (int, error) {
+	val, ok := values[name]
+	if !ok || val == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(val))
+	if err != nil {
+		return 0, newError(msgVersionNumber, name, val)
+	}
+	return n, nil
+}
+
+// unchanged returns true if the pack of the writer is the same as in the last delivery, so it is not
+// delivered again
+func unchanged(wr writer) bool {
+	vw, ok := wr.(*versionWriter)
+	return ok && vw.action == versionUnchanged
+}
+
+// unwrapWriter returns the writer of the output format of a pack
+func unwrapWriter(wr writer) writer {
+	if vw, ok := wr.(*versionWriter); ok {
+		return vw.writer
+	}
+	return wr
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// adiDTD is the CableLabs ADI 1.1 DTD (MD-SP-ADI1.1). The xmlns attribute of ADI is the
+// PRODIS namespace written by the operator configs
+const adiDTD = `
+<!ELEMENT ADI (Metadata, Asset*)>
+<!ATTLIST ADI xmlns CDATA #IMPLIED>
+<!ELEMENT Metadata (AMS, App_Data*)>
+<!ELEMENT AMS EMPTY>
+<!ATTLIST AMS
+	Asset_Name CDATA #REQUIRED
+	Provider CDATA #REQUIRED
+	Product CDATA #REQUIRED
+	Version_Major CDATA #REQUIRED
+	Version_Minor CDATA #REQUIRED
+	Description CDATA #REQUIRED
+	Creation_Date CDATA #REQUIRED
+	Provider_ID CDATA #REQUIRED
+	Asset_ID CDATA #REQUIRED
+	Asset_Class CDATA #REQUIRED
+	Verb CDATA #IMPLIED>
+<!ELEMENT App_Data EMPTY>
+<!ATTLIST App_Data
+	App CDATA #REQUIRED
+	Name CDATA #REQUIRED
+	Value CDATA #REQUIRED>
+<!ELEMENT Asset (Metadata, Asset*, Content?)>
+<!ELEMENT Content EMPTY>
+<!ATTLIST Content Value CDATA #REQUIRED>
+`
+
+// xsdRuleT is a constraint of an element (or attribute, "@name") in the style of a XML schema
+type xsdRuleT struct {
+	path      string // from the root, e.g. "assetPackages/assetPackage/@type"
+	minOccurs int
+	maxOccurs int // 0: unbounded
+	maxLen    int
+	pattern   string
+	values    []string
+}
+
+// Oi MediaHub rules, from the VOD metadata reference guide (docs/oi)
+var mediaHubRules = func() []xsdRuleT {
+	p := "assetPackages/assetPackage"
+	m := p + "/metadata"
+	a := p + "/asset"
+	dateTime := `^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}`
+	return []xsdRuleT{
+		{path: p, minOccurs: 1},
+		{path: p + "/@type", minOccurs: 1, values: []string{"VOD", "SVOD"}},
+		{path: p + "/metadata", minOccurs: 1, maxOccurs: 1},
+		{path: p + "/businessMetadata", minOccurs: 1, maxOccurs: 1},
+		{path: p + "/rightsMetadata", minOccurs: 1, maxOccurs: 1},
+		{path: p + "/asset", minOccurs: 2, maxOccurs: 3},
+		{path: m + "/assetID", minOccurs: 1, maxOccurs: 1},
+		{path: m + "/providerID", minOccurs: 1, maxOccurs: 1},
+		{path: m + "/showType", minOccurs: 1, maxOccurs: 1},
+		{path: m + "/title", minOccurs: 1, maxLen: 254},
+		{path: m + "/sortTitle", maxLen: 100},
+		{path: m + "/reducedTitle", minOccurs: 1, maxLen: 100},
+		{path: m + "/summary", minOccurs: 1, maxLen: 1024},
+		{path: m + "/shortSummary", minOccurs: 1, maxLen: 254},
+		{path: m + "/cgmsaLevel", minOccurs: 1, maxOccurs: 1},
+		{path: m + "/rating", minOccurs: 1},
+		{path: m + "/runTimeMinutes", minOccurs: 1, maxOccurs: 1, pattern: `^[1-9][0-9]*$`},
+		{path: m + "/release_year", minOccurs: 1, maxOccurs: 1, pattern: `^[0-9]{4}$`},
+		{path: m + "/person", maxOccurs: 100},
+		{path: m + "/person/@role", minOccurs: 1, values: []string{"actor", "director", "writer", "producer",
+			"ExecutiveProducer", "host", "guestStar", "artist", "composer", "Actor", "Director", "Writer",
+			"Producer", "Host", "GuestStar", "Artist", "Composer"}},
+		{path: m + "/person/@lname", minOccurs: 1, maxLen: 50},
+		{path: m + "/person/@fname", minOccurs: 1, maxLen: 50},
+		{path: m + "/person/@mname", minOccurs: 1, maxLen: 50},
+		{path: m + "/studio", maxLen: 100},
+		{path: m + "/studioDisplayName", maxLen: 255},
+		{path: m + "/category", minOccurs: 1, maxOccurs: 100, maxLen: 255},
+		{path: m + "/genre", minOccurs: 1},
+		{path: m + "/autoImport", minOccurs: 1, maxOccurs: 1, values: []string{"true", "false"}},
+		{path: m + "/autoDeploy", minOccurs: 1, maxOccurs: 1, values: []string{"true", "false"}},
+		{path: m + "/additionalInfo", minOccurs: 1, maxOccurs: 1},
+		{path: p + "/businessMetadata/suggestedPrice", minOccurs: 1, maxOccurs: 1, pattern: `^[0-9]+(\.[0-9]+)?$`},
+		{path: p + "/businessMetadata/currency_iso3166-2", minOccurs: 1, maxOccurs: 1},
+		{path: p + "/rightsMetadata/licensingWindowStart", minOccurs: 1, maxOccurs: 1, pattern: dateTime},
+		{path: p + "/rightsMetadata/licensingWindowEnd", maxOccurs: 1, pattern: dateTime},
+		{path: p + "/rightsMetadata/availabilityWindowStart", maxOccurs: 1, pattern: dateTime},
+		{path: p + "/rightsMetadata/availabilityWindowEnd", maxOccurs: 1, pattern: dateTime},
+		{path: a + "/@type", minOccurs: 1, values: []string{"feature", "trailer", "poster"}},
+		{path: a + "/@asset_name", minOccurs: 1, maxLen: 255},
+		{path: a + "/metadata", minOccurs: 1, maxOccurs: 1},
+		{path: a + "/content", minOccurs: 1, maxOccurs: 1},
+		{path: a + "/metadata/assetID", minOccurs: 1, maxOccurs: 1},
+		{path: a + "/metadata/providerID", minOccurs: 1, maxOccurs: 1},
+		{path: a + "/metadata/HD", maxOccurs: 1, values: []string{"true", "false"}},
+		{path: a + "/metadata/screenFormat", maxOccurs: 1, values: []string{"Widescreen", "Letterbox", "Standard"}},
+	}
+}()
+
+// xmlSchemaT validates the documents of a root element, with a DTD and/or XSD-style rules
+type xmlSchemaT struct {
+	dtd   *dtdT
+	rules []xsdRuleT
+}
+
+// Bundled schemas, by root element
+var xmlSchemas = map[string]*xmlSchemaT{
+	"ADI":           {dtd: mustParseDTD(adiDTD)},
+	"assetPackages": {rules: mediaHubRules},
+}
+
+// dtdT holds the element declarations of a DTD
+type dtdT struct {
+	elements map[string]*dtdElemT
+}
+
+// dtdElemT is an element declaration
+type dtdElemT struct {
+	model string         // content model, as declared
+	re    *regexp.Regexp // children sequence, for element content
+	empty bool
+	any   bool
+	mixed map[string]bool // children allowed in mixed content (#PCDATA)
+	attrs map[string]dtdAttrT
+}
+
+// dtdAttrT is an attribute declaration
+type dtdAttrT struct {
+	required bool
+	fixed    string
+	values   []string // enumeration
+}
+
+var (
+	dtdCommentRe = regexp.MustCompile(`(?s)<!--.*?-->`)
+	dtdDeclRe    = regexp.MustCompile(`(?s)<!(ELEMENT|ATTLIST)\s+(\S+)\s+(.*?)>`)
+	dtdAttrRe    = regexp.MustCompile(`(\S+)\s+(CDATA|ID|IDREFS?|NMTOKENS?|ENTITY|ENTITIES|\([^)]*\))\s+` +
+		`(#REQUIRED|#IMPLIED|#FIXED\s+"[^"]*"|"[^"]*")`)
+)
+
+// parseDTD reads the ELEMENT and ATTLIST declarations of a DTD
+func parseDTD(src string) (*dtdT, error) {
+	dtd := &dtdT{elements: make(map[string]*dtdElemT)}
+	src = dtdCommentRe.ReplaceAllString(src, "")
+	// elements before attributes
+	for _, kind := range []string{"ELEMENT", "ATTLIST"} {
+		for _, m := range dtdDeclRe.FindAllStringSubmatch(src, -1) {
+			if m[1] != kind {
+				continue
+			}
+			name, body := m[2], strings.TrimSpace(m[3])
+			if kind == "ELEMENT" {
+				el, err := parseContentModel(body)
+				if err != nil {
+					return nil, newError(msgDTDElement, name, err)
+				}
+				dtd.elements[name] = el
+				continue
+			}
+			el, ok := dtd.elements[name]
+			if !ok {
+				return nil, newError(msgDTDAttlist, name)
+			}
+			for _, a := range dtdAttrRe.FindAllStringSubmatch(body, -1) {
+				attr := dtdAttrT{required: a[3] == "#REQUIRED"}
+				if strings.HasPrefix(a[2], "(") {
+					for _, v := range strings.Split(strings.Trim(a[2], "()"), "|") {
+						attr.values = append(attr.values, strings.TrimSpace(v))
+					}
+				}
+				if strings.HasPrefix(a[3], "#FIXED") {
+					attr.fixed = strings.Trim(strings.TrimSpace(strings.TrimPrefix(a[3], "#FIXED")), `"`)
+				}
+				el.attrs[a[1]] = attr
+			}
+		}
+	}
+	return dtd, nil
+}
+
+// mustParseDTD parses a bundled DTD
+func mustParseDTD(src string) *dtdT {
+	dtd, err := parseDTD(src)
+	if err != nil {
+		panic(err)
+	}
+	return dtd
+}
+
+// parseContentModel converts a content model to a regexp over the sequence of children "<a><b>"
+func parseContentModel(model string) (*dtdElemT, error) {
+	el := &dtdElemT{model: model, attrs: make(map[string]dtdAttrT)}
+	switch {
+	case model == "EMPTY":
+		el.empty = true
+		return el, nil
+	case model == "ANY":
+		el.any = true
+		return el, nil
+	case strings.HasPrefix(strings.Replace(model, " ", "", -1), "(#PCDATA"):
+		el.mixed = make(map[string]bool)
+		for _, n := range strings.Split(strings.TrimRight(strings.TrimSpace(model), "*"), "|")[1:] {
+			el.mixed[strings.Trim(strings.TrimSpace(n), "()")] = true
+		}
+		return el, nil
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(model); i++ {
+		c := model[i]
+		switch {
+		case c == '(':
+			b.WriteString("(?:")
+		case c == ')', c == '|', c == '?', c == '*', c == '+':
+			b.WriteByte(c)
+		case c == ',', c == ' ', c == '\t', c == '\n', c == '\r':
+		case isNameChar(c):
+			j := i
+			for j < len(model) && isNameChar(model[j]) {
+				j++
+			}
+			b.WriteString("(?:<" + regexp.QuoteMeta(model[i:j]) + ">)")
+			i = j - 1
+		default:
+			return nil, newError(msgContentModel, model)
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, newError(msgContentModelRegexp, model, err)
+	}
+	el.re = re
+	return el, nil
+}
+
+// isNameChar returns true if c can be part of a XML name
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '-' || c == '.' || c == ':'
+}
+
+// xmlFrameT is an open element while validating a document
+type xmlFrameT struct {
+	name     string
+	path     string
+	line     int
+	decl     *dtdElemT
+	children []string
+	counts   map[string]int
+	attrs    map[string]string
+	text     strings.Builder
+}
+
+// validateXML validates a document against the bundled schema of its root element. Documents
+// without a bundled schema are not validated
+func validateXML(content []byte) (errs []error) {
+	content = decodeUTF16(content)
+	dec := xml.NewDecoder(bytes.NewReader(content))
+	dec.CharsetReader = xmlCharsetReader
+	lineAt := func(offset int64) int {
+		return bytes.Count(content[:offset], []byte("\n")) + 1
+	}
+	var schema *xmlSchemaT
+	var doctype string
+	var stack []*xmlFrameT
+	for {
+		offset := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return append(errs, newError(msgXMLMalformed, err))
+		}
+		switch t := tok.(type) {
+		case xml.Directive:
+			if f := strings.Fields(string(t)); len(f) > 1 && f[0] == "DOCTYPE" {
+				doctype = f[1]
+			}
+		case xml.StartElement:
+			fr := &xmlFrameT{name: t.Name.Local, line: lineAt(offset), counts: make(map[string]int),
+				attrs: make(map[string]string)}
+			for _, a := range t.Attr {
+				key := a.Name.Local
+				if a.Name.Space == "xmlns" {
+					key = "xmlns:" + key
+				}
+				fr.attrs[key] = a.Value
+			}
+			if len(stack) == 0 {
+				if doctype != "" && doctype != fr.name {
+					errs = append(errs, newError(msgRootMismatch, fr.name, doctype))
+				}
+				if schema = xmlSchemas[fr.name]; schema == nil {
+					return
+				}
+				fr.path = fr.name
+			} else {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, fr.name)
+				parent.counts[fr.name]++
+				fr.path = parent.path + "/" + fr.name
+			}
+			if schema.dtd != nil {
+				errs = append(errs, schema.dtd.checkStart(fr)...)
+			}
+			stack = append(stack, fr)
+		case xml.CharData:
+			if len(stack) == 0 {
+				continue
+			}
+			fr := stack[len(stack)-1]
+			fr.text.Write(t)
+			if fr.decl != nil && (fr.decl.re != nil || fr.decl.empty) {
+				if strings.TrimSpace(string(t)) != "" {
+					errs = append(errs, newError(msgTextNotAllowed, fr.name, fr.line))
+				}
+			}
+		case xml.EndElement:
+			fr := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if schema.dtd != nil {
+				errs = append(errs, checkChildren(fr)...)
+			}
+			errs = append(errs, checkXsdRules(schema.rules, fr)...)
+		}
+	}
+	return
+}
+
+// checkStart checks if an element and its attributes are declared
+func (dtd *dtdT) checkStart(fr *xmlFrameT) (errs []error) {
+	fr.decl = dtd.elements[fr.name]
+	if fr.decl == nil {
+		return []error{newError(msgElementUndeclared, fr.name, fr.line)}
+	}
+	keys := make([]string, 0, len(fr.attrs))
+	for k := range fr.attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		attr, ok := fr.decl.attrs[k]
+		if !ok {
+			errs = append(errs, newError(msgAttrUndeclared, k, fr.name, fr.line))
+			continue
+		}
+		if attr.fixed != "" && fr.attrs[k] != attr.fixed {
+			errs = append(errs, newError(msgAttrFixed,
+				k, fr.name, attr.fixed, fr.line))
+		}
+		if len(attr.values) > 0 && !contains(attr.values, fr.attrs[k]) {
+			errs = append(errs, newError(msgAttrEnum,
+				fr.attrs[k], k, fr.name, attr.values, fr.line))
+		}
+	}
+	names := make([]string, 0, len(fr.decl.attrs))
+	for k := range fr.decl.attrs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		if _, ok := fr.attrs[k]; !ok && fr.decl.attrs[k].required {
+			errs = append(errs, newError(msgAttrRequired, k, fr.name, fr.line))
+		}
+	}
+	return
+}
+
+// checkChildren checks the children of an element against its content model
+func checkChildren(fr *xmlFrameT) []error {
+	el := fr.decl
+	switch {
+	case el == nil || el.any:
+		return nil
+	case el.empty:
+		if len(fr.children) > 0 {
+			return []error{newError(msgElementNotEmpty, fr.name, fr.line)}
+		}
+	case el.mixed != nil:
+		for _, c := range fr.children {
+			if !el.mixed[c] {
+				return []error{newError(msgElementNotAllowed, c, fr.name, fr.line)}
+			}
+		}
+	default:
+		var b strings.Builder
+		for _, c := range fr.children {
+			b.WriteString("<" + c + ">")
+		}
+		if !el.re.MatchString(b.String()) {
+			return []error{newError(msgContentInvalid,
+				fr.name, fr.line, strings.Join(fr.children, ", "), el.model)}
+		}
+	}
+	return nil
+}
+
+// checkXsdRules checks the rules of an element, of its attributes and of the occurrences of its children
+func checkXsdRules(rules []xsdRuleT, fr *xmlFrameT) (errs []error) {
+	for _, r := range rules {
+		parent, name := r.path, ""
+		if i := strings.LastIndex(r.path, "/"); i >= 0 {
+			parent, name = r.path[:i], r.path[i+1:]
+		}
+		switch {
+		case r.path == fr.path:
+			errs = append(errs, r.checkValue(fr.name, strings.TrimSpace(fr.text.String()), fr.line)...)
+		case parent == fr.path && strings.HasPrefix(name, "@"):
+			val, ok := fr.attrs[name[1:]]
+			if !ok {
+				if r.minOccurs > 0 {
+					errs = append(errs, newError(msgAttrRequired,
+						name[1:], fr.name, fr.line))
+				}
+				continue
+			}
+			errs = append(errs, r.checkValue(fr.name+"/"+name, val, fr.line)...)
+		case parent == fr.path:
+			n := fr.counts[name]
+			if n < r.minOccurs {
+				errs = append(errs, newError(msgMinOccurs,
+					fr.name, r.minOccurs, name, n, fr.line))
+			}
+			if r.maxOccurs > 0 && n > r.maxOccurs {
+				errs = append(errs, newError(msgMaxOccurs,
+					fr.name, r.maxOccurs, name, n, fr.line))
+			}
+		}
+	}
+	return
+}
+
+// checkValue checks the value of an element or attribute
+func (r xsdRuleT) checkValue(name string, val string, line int) (errs []error) {
+	if r.maxLen > 0 && len([]rune(val)) > r.maxLen {
+		errs = append(errs, newError(msgMaxLength, name, r.maxLen, line))
+	}
+	if r.pattern != "" && !regexp.MustCompile(r.pattern).MatchString(val) {
+		errs = append(errs, newError(msgValueInvalid, val, name, line))
+	}
+	if len(r.values) > 0 && !contains(r.values, val) {
+		errs = append(errs, newError(msgValueEnum, val, name, r.values, line))
+	}
+	return
+}
+
+// xmlCharsetReader decodes the encodings written by the XML writer
+func xmlCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToUpper(charset) {
+	case "ISO-8859-1", "LATIN1":
+		return charmap.ISO8859_1.NewDecoder().Reader(input), nil
+	case "UTF-16":
+		// already converted to UTF-8 by validateXML
+		return input, nil
+	}
+	return nil, newError(msgUnsupportedCharset, charset)
+}
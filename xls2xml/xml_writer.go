@@ -4,13 +4,14 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
-	"math"
+	"strings"
 	"time"
 
 	"golang.org/x/text/encoding"
 
 	xw "github.com/shabbyrobe/xmlwriter"
 	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
 )
 
 const (
@@ -18,10 +19,48 @@ const (
 	commentS = 1
 )
 
+// Encodings of the XML output, selected by the option 'output_encoding'
+const (
+	encodingLatin1 = "ISO-8859-1"
+	encodingUTF8   = "UTF-8"
+	encodingUTF16  = "UTF-16"
+)
+
+// outputEncoding returns the encoding of the XML output. Default: ISO-8859-1
+func outputEncoding(options optionsT) (string, error) {
+	enc := options["options"]["output_encoding"]
+	switch strings.ToUpper(strings.TrimSpace(enc)) {
+	case "", "ISO-8859-1", "ISO8859-1", "LATIN1":
+		return encodingLatin1, nil
+	case "UTF-8", "UTF8":
+		return encodingUTF8, nil
+	case "UTF-16", "UTF16":
+		return encodingUTF16, nil
+	}
+	return encodingLatin1, newError(msgOutputEncoding, enc)
+}
+
+// utf16Encoding is the UTF-16 of the output: little endian with BOM
+var utf16Encoding = unicode.UTF16(unicode.LittleEndian, unicode.UseBOM)
+
+// decodeUTF16 converts to UTF-8 a content starting with a UTF-16 BOM. Other contents are returned unchanged
+func decodeUTF16(content []byte) []byte {
+	if !bytes.HasPrefix(content, []byte{0xFF, 0xFE}) && !bytes.HasPrefix(content, []byte{0xFE, 0xFF}) {
+		return content
+	}
+	decoded, err := unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder().Bytes(content)
+	if err != nil {
+		return content
+	}
+	return decoded
+}
+
 // xmlWriter writes XML files
 type xmlWriter struct {
+	run      *runT
 	fileName string
 	systemID string
+	encoding string
 	w        *xw.Writer
 	b        *bytes.Buffer
 	ec       *xw.ErrCollector
@@ -41,8 +80,12 @@ func (wr *xmlWriter) EndMap() error {
 }
 
 // NewXMLWriter creates a new struct
-func newXMLWriter(filename string, systemID string) (*xmlWriter, error) {
-	w := xmlWriter{fileName: filename, systemID: systemID, testing: false}
+func newXMLWriter(run *runT, filename string, systemID string) (*xmlWriter, error) {
+	enc, err := outputEncoding(run.options)
+	if err != nil {
+		return nil, err
+	}
+	w := xmlWriter{run: run, fileName: filename, systemID: systemID, encoding: enc, testing: false}
 	return &w, nil
 }
 
@@ -75,59 +118,69 @@ func (wr *xmlWriter) Write(value string) error {
 
 // WriteAttr adds an attribute to the current XML attribute
 func (wr *xmlWriter) WriteAttr(name string, value string, vtype string, attrType string) (err2 error) {
-	ERRS := "#ERRO#"
-	var val string
+	val, err2 := xmlValue(name, value, vtype)
+	if attrType == "ott" {
+		if val != "" {
+			wr.ec.Do(
+				wr.Write(val),
+			)
+			if wr.ec.Err != nil {
+				return fmt.Errorf(wr.ec.Error())
+			}
+		}
+	} else {
+		wr.ec.Do(wr.w.WriteAttr(xw.Attr{Name: name, Value: val}))
+		if wr.ec.Err != nil {
+			return fmt.Errorf(wr.ec.Error())
+		}
+	}
+	return
+}
+
+// xmlValue converts a value to the XML representation of its type. Values that can't be converted are
+// replaced by errorMessage and a conversionErrorT is returned
+func xmlValue(name string, value string, vtype string) (val string, err2 error) {
+	ERRS := errorMessage[0].val
 	switch vtype {
 	case "", "string":
 		val = value
 	case "int":
 		val = value
 	case "time":
-		sec, err := toTimeSeconds(value)
+		d, err := parseDuration(value)
 		if err != nil {
-			val, err2 = ERRS, fmt.Errorf("ERRO: Falha na conversao de campo para HH:MM (%s): [%s]", name, value)
+			val, err2 = ERRS, newConversionError(name, value, vtype, err)
 			break
 		}
-		hours := sec / 3600
-		minutes := int64(math.Ceil((float64(sec) - float64(hours)*3600) / 60))
-		val = fmt.Sprintf("%02d:%02d", hours, minutes)
+		val = d.hoursMinutes()
 	case "time_s":
-		sec, err := toTimeSeconds(value)
+		d, err := parseDuration(value)
 		if err != nil {
-			val, err2 = ERRS, fmt.Errorf("ERRO: Falha na conversao de tempo para segundos (%s): [%s]", name, value)
+			val, err2 = ERRS, newConversionError(name, value, vtype, err)
 			break
 		}
-		val = fmt.Sprintf("%d", sec)
+		val = fmt.Sprintf("%d", d.seconds())
 	case "time_m":
-		sec, err := toTimeSeconds(value)
+		d, err := parseDuration(value)
 		if err != nil {
-			val, err2 = ERRS, fmt.Errorf("ERRO: Falha na conversao de tempo para minutos (%s): [%s]", name, value)
+			val, err2 = ERRS, newConversionError(name, value, vtype, err)
 			break
 		}
-		min := int64(math.Ceil(float64(sec) / 60.0))
-		val = fmt.Sprintf("%d", min)
+		val = fmt.Sprintf("%d", d.minutes())
+	case "timestamp":
+		d, err := parseDuration(value)
+		if err != nil {
+			val, err2 = ERRS, newConversionError(name, value, vtype, err)
+			break
+		}
+		val = fmt.Sprintf("%d", d.millis())
 	case "boolean":
 		if value != "true" && value != "false" {
-			val, err2 = ERRS, fmt.Errorf("ERRO: Valor booleano deve ser 'true' ou 'false' (%s): [%s]", name, value)
+			val, err2 = ERRS, newConversionError(name, value, vtype, newError(msgInvalidBoolean))
 			break
 		}
 		val = value
 	}
-	if attrType == "ott" {
-		if val != "" {
-			wr.ec.Do(
-				wr.Write(val),
-			)
-			if wr.ec.Err != nil {
-				return fmt.Errorf(wr.ec.Error())
-			}
-		}
-	} else {
-		wr.ec.Do(wr.w.WriteAttr(xw.Attr{Name: name, Value: val}))
-		if wr.ec.Err != nil {
-			return fmt.Errorf(wr.ec.Error())
-		}
-	}
 	return
 }
 
@@ -162,9 +215,16 @@ func (wr *xmlWriter) EndComment(_ string) error {
 
 // OpenOutput prepares to write a XML file
 func (wr *xmlWriter) OpenOutput() (err error) {
-	encod := encoding.HTMLEscapeUnsupported(charmap.ISO8859_1.NewEncoder())
 	wr.b = &bytes.Buffer{}
-	wr.w = xw.OpenEncoding(wr.b, "ISO-8859-1", encod, xw.WithIndentString("\t"))
+	switch wr.encoding {
+	case encodingUTF8:
+		wr.w = xw.Open(wr.b, xw.WithIndentString("\t"))
+	case encodingUTF16:
+		wr.w = xw.OpenEncoding(wr.b, encodingUTF16, utf16Encoding.NewEncoder(), xw.WithIndentString("\t"))
+	default:
+		encod := encoding.HTMLEscapeUnsupported(charmap.ISO8859_1.NewEncoder())
+		wr.w = xw.OpenEncoding(wr.b, encodingLatin1, encod, xw.WithIndentString("\t"))
+	}
 	wr.ec = &xw.ErrCollector{}
 	doc := xw.Doc{}
 	err = wr.w.StartDoc(doc)
@@ -187,7 +247,7 @@ func (wr *xmlWriter) OpenOutput() (err error) {
 func (wr *xmlWriter) WriteAndClose(filename string) (err error) {
 	err = wr.w.EndAllFlush()
 	if err != nil {
-		err = fmt.Errorf("ERRO ao criar arquivo [%#v]: %v", filename, err)
+		err = newError(msgCreateFile, filename, err)
 		return
 	}
 	if wr.testing {
@@ -195,9 +255,10 @@ func (wr *xmlWriter) WriteAndClose(filename string) (err error) {
 	}
 	err = ioutil.WriteFile(filename, wr.b.Bytes(), 0644)
 	if err != nil {
-		err = fmt.Errorf("ERRO ao criar arquivo [%#v]: %v", filename, err)
+		err = newError(msgCreateFile, filename, err)
 		return
 	}
+	wr.run.registerOutput(filename)
 	return
 }
 